@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// argHelp describes the typed literals accepted for bind parameters
+const argHelp = "prefix with int:, real:, bool:, text:, or blob: (hex, or @file) to set the type; null: binds NULL"
+
+// parseArg converts a command line value into a bind parameter, with
+// an optional prefix for its type, e.g., "int:42" or "blob:@photo.jpg".
+// Values without a recognized prefix are bound as text.
+func parseArg(s string) (interface{}, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return s, nil
+	}
+	kind, value := s[:i], s[i+1:]
+	switch kind {
+	case "int":
+		n, err := strconv.ParseInt(value, 0, 64)
+		return n, errors.Wrapf(err, "invalid int: %q", value)
+	case "real":
+		f, err := strconv.ParseFloat(value, 64)
+		return f, errors.Wrapf(err, "invalid real: %q", value)
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		return b, errors.Wrapf(err, "invalid bool: %q", value)
+	case "text":
+		return value, nil
+	case "null":
+		return nil, nil
+	case "blob":
+		if strings.HasPrefix(value, "@") {
+			b, err := ioutil.ReadFile(value[1:])
+			return b, errors.Wrapf(err, "can't read blob file: %s", value[1:])
+		}
+		b, err := hex.DecodeString(value)
+		return b, errors.Wrapf(err, "invalid hex blob: %q", value)
+	}
+	// e.g., a time of day or a url
+	return s, nil
+}
+
+// bindArgs returns the positional and named (k=v) command line values as
+// parameters for the driver to bind
+func bindArgs(positional, named []string) ([]interface{}, error) {
+	args := make([]interface{}, 0, len(positional)+len(named))
+	for _, s := range positional {
+		arg, err := parseArg(s)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	for _, s := range named {
+		i := strings.Index(s, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("named parameter must be name=value: %q", s)
+		}
+		// sqlite allows :name, @name, and $name in the statement
+		name := strings.TrimLeft(s[:i], ":@$")
+		arg, err := parseArg(s[i+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parameter: %s", name)
+		}
+		args = append(args, sql.Named(name, arg))
+	}
+	return args, nil
+}
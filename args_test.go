@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseArg(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"plain", "plain"},
+		{"int:42", int64(42)},
+		{"int:0x10", int64(16)},
+		{"real:1.5", 1.5},
+		{"bool:true", true},
+		{"text:int:42", "int:42"},
+		{"null:", nil},
+		{"blob:cafe", []byte{0xca, 0xfe}},
+		{"12:30", "12:30"},
+		{"http://example.com", "http://example.com"},
+	}
+	for _, test := range tests {
+		got, err := parseArg(test.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %#v, want %#v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseArgInvalid(t *testing.T) {
+	for _, in := range []string{"int:forty", "real:x", "bool:maybe", "blob:xyz", "blob:@/no/such/file"} {
+		if _, err := parseArg(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestParseArgBlobFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "blob")
+	if err := ioutil.WriteFile(fileName, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseArg("blob:@" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []byte("hello")) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestBindArgs(t *testing.T) {
+	got, err := bindArgs([]string{"a", "int:1"}, []string{"name=bob", ":id=int:7", "$when=12:30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		"a",
+		int64(1),
+		sql.Named("name", "bob"),
+		sql.Named("id", int64(7)),
+		sql.Named("when", "12:30"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestBindArgsInvalid(t *testing.T) {
+	for _, named := range []string{"noequals", "=value", "n=int:x"} {
+		if _, err := bindArgs(nil, []string{named}); err == nil {
+			t.Errorf("%q: expected an error", named)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 
 	app "github.com/canonical/go-dqlite/app"
@@ -60,47 +61,38 @@ func NewLogLog(level client.LogLevel) client.LogFunc {
 }
 
 func getLeader(ctx context.Context, pair *KeyPair, cluster []string) (*client.Client, error) {
-	store := getStore(ctx, cluster)
 	logFunc := NewLogFunc(defaultLogLevel, "", nil)
-
-	dial := client.DefaultDialFunc
-	log.Println("get leader")
-	if pair != nil && pair.Cert != "" {
-		cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
-		if err != nil {
-			return nil, err
-		}
-		data, err := ioutil.ReadFile(pair.Cert)
-		if err != nil {
-			return nil, err
-		}
-
-		pool := x509.NewCertPool()
-		if !pool.AppendCertsFromPEM(data) {
-			return nil, fmt.Errorf("bad certificate")
-		}
-
-		config := app.SimpleDialTLSConfig(cert, pool)
-		dial = client.DialFuncWithTLS(dial, config)
-		log.Println("using TLS encryption")
-
+	dial, err := dialFunc(pair)
+	if err != nil {
+		return nil, err
+	}
+	store, err := getStore(ctx, cluster, net.DefaultResolver, dial, logFunc)
+	if err != nil {
+		return nil, err
 	}
-
 	return client.FindLeader(ctx, store, client.WithLogFunc(logFunc), client.WithDialFunc(dial))
 }
 
-func getStore(ctx context.Context, cluster []string) client.NodeStore {
-	//log.Println("GET STORE FOR CLUSTER:", cluster)
-	store := client.NewInmemNodeStore()
-	if len(cluster) == 0 {
-		cluster = defaultCluster
+// dialFunc returns the function for connecting to nodes, using TLS if a key pair is given
+func dialFunc(pair *KeyPair) (client.DialFunc, error) {
+	dial := client.DefaultDialFunc
+	if pair == nil || pair.Cert == "" {
+		return dial, nil
+	}
+	cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+	if err != nil {
+		return nil, err
 	}
-	infos := make([]client.NodeInfo, len(cluster))
-	for i, address := range cluster {
-		infos[i].ID = uint64(i + 1)
-		infos[i].Address = address
+	data, err := ioutil.ReadFile(pair.Cert)
+	if err != nil {
+		return nil, err
 	}
-	//log.Println("INFOS:", infos)
-	store.Set(ctx, infos)
-	return store
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("bad certificate")
+	}
+
+	config := app.SimpleDialTLSConfig(cert, pool)
+	return client.DialFuncWithTLS(dial, config), nil
 }
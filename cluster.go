@@ -2,8 +2,7 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -20,6 +19,7 @@ import (
 	dqlite "github.com/canonical/go-dqlite"
 	app "github.com/canonical/go-dqlite/app"
 	dqclient "github.com/canonical/go-dqlite/client"
+	"github.com/paulstuart/dqlited/server"
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
@@ -196,15 +196,8 @@ const (
 )
 
 func nodeRole(s string) (NodeRole, error) {
-	switch strings.ToLower(s) {
-	case "voter":
-		return Voter, nil
-	case "standby", "stand-by":
-		return Standby, nil
-	case "spare":
-		return Spare, nil
-	}
-	return NodeRole(255), fmt.Errorf("invalid role name: %q", s)
+	role, err := server.ParseRole(s)
+	return NodeRole(role), err
 }
 
 // ClientFunc is an interface to run commands from a CLI
@@ -287,94 +280,110 @@ func nodeAdd(ctx context.Context, client *dqclient.Client, id uint64, role NodeR
 
 const defaultNetworkLatency = 20 * time.Millisecond
 
-// StartServer provides a web interface to the database
-// No error to return as it's never intended to stop
-// TODO: too many args, consolidate into config struct
-// TODO: is ctx n/a here?
-func StartServer(ctx context.Context, id, port int, keyPair *KeyPair, dir, address string, cluster []string) error {
-	log.Printf("starting server node:%d address:%q dir:%q ip:%s cluster:%v\n", id, address, dir, myIP(), cluster)
+// handoverTimeout is how long a stopping server has to hand over leadership (and leave)
+const handoverTimeout = time.Minute
 
-	// TODO: do we need to set up db now?
-	dbname := ""
-	if dbname != "" {
-		dir = filepath.Join(dir, dbname)
+// StartServer runs a server node with the web api until it is signaled to stop
+func StartServer(ctx context.Context, cfg server.ServerConfig) error {
+	cfg.Handlers = webHandlers
+	s, err := server.New(cfg)
+	if err != nil {
+		return err
 	}
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return errors.Wrapf(err, "can't create %s", dir)
+	if err := s.Start(ctx); err != nil {
+		return err
 	}
-	logfun := NewLogLog(dqclient.LogDebug)
+	log.Printf("node %d web api on: %s (ip: %s)\n", cfg.ID, cfg.Web, myIP())
 
-	options := []app.Option{app.WithAddress(address), app.WithCluster(cluster), app.WithLogFunc(logfun)}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, unix.SIGPWR)
+	signal.Notify(ch, unix.SIGINT)
+	signal.Notify(ch, unix.SIGQUIT)
+	signal.Notify(ch, unix.SIGTERM)
 
-	/*
-		crt := "cluster.crt"
-		key := "cluster.key"
-		if crt != "" {
-	*/
-	if keyPair != nil && keyPair.Cert != "" {
-		cert, err := tls.LoadX509KeyPair(keyPair.Cert, keyPair.Key)
-		if err != nil {
-			return err
-		}
+	select {
+	case sig := <-ch:
+		log.Println("shutting down on signal:", sig)
+	case <-s.Done():
+		log.Println("shutting down on request")
+	}
+	// leave time to hand over once writes have drained
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout+handoverTimeout)
+	defer cancel()
+	return s.Stop(ctx)
+}
 
-		data, err := ioutil.ReadFile(keyPair.Cert)
+// databaseSetup returns a function that creates the database, if it does not
+// yet exist, and applies the schema file to it as a single transaction.
+// As every node applies the schema when it starts, its statements should be
+// idempotent (e.g., CREATE TABLE IF NOT EXISTS).
+func databaseSetup(dbName, schema string) func(context.Context, *app.App) error {
+	return func(ctx context.Context, dq *app.App) error {
+		if dbName == "" {
+			return nil
+		}
+		db, err := dq.Open(ctx, dbName)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "can't open database: %s", dbName)
 		}
-
-		pool := x509.NewCertPool()
-		if !pool.AppendCertsFromPEM(data) {
-			return fmt.Errorf("bad certificate")
+		defer db.Close()
+		if err := db.PingContext(ctx); err != nil {
+			return errors.Wrapf(err, "can't create database: %s", dbName)
 		}
-
-		listen, dial := app.SimpleTLSConfig(cert, pool)
-		options = append(options, app.WithTLS(listen, dial))
+		if schema == "" {
+			return nil
+		}
+		log.Printf("applying schema %s to database: %s\n", schema, dbName)
+		dx := &DBX{db: db, name: dbName, w: ioutil.Discard}
+		return dx.loadFile(schema, true)
 	}
-	dq, err := app.New(dir, options...)
+}
+
+// nodeWeb returns the address of the web api of the node with the given id,
+// which is assumed to be on the node's host at the given port
+func nodeWeb(ctx context.Context, pair *KeyPair, id uint64, port int, cluster []string) (string, error) {
+	client, err := getLeader(ctx, pair, cluster)
 	if err != nil {
-		return errors.Wrap(err, "no new app for you!")
+		return "", err
 	}
-
-	// TODO: add host option
-	web := fmt.Sprintf("0.0.0.0:%d", port)
-	m := http.NewServeMux()
-	s := http.Server{Addr: web, Handler: m}
-	for _, handler := range webHandlers(ctx, dq) {
-		m.HandleFunc(handler.Path, handler.Func)
+	defer client.Close()
+	nodes, err := client.Cluster(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "can't get cluster")
 	}
-
-	ch := make(chan os.Signal)
-
-	m.HandleFunc("/shutdown", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodDelete {
-			fmt.Fprintln(w, "that method is the wrong one")
-			return
+	for _, node := range nodes {
+		if node.ID != id {
+			continue
+		}
+		host, _, err := net.SplitHostPort(node.Address)
+		if err != nil {
+			return "", err
 		}
-		fmt.Fprintln(w, "shutting down")
-		ch <- unix.SIGQUIT
-	})
+		return net.JoinHostPort(host, fmt.Sprint(port)), nil
+	}
+	return "", fmt.Errorf("no such node: %d", id)
+}
 
-	listener, err := net.Listen("tcp", web)
+// Maintain drains (or undrains) the node whose web api is at the given address
+func Maintain(ctx context.Context, web string, drain, demote bool) (*server.Maintenance, error) {
+	method := http.MethodDelete
+	if drain {
+		method = http.MethodPost
+	}
+	url := fmt.Sprintf("http://%s/maintenance?demote=%t", web, demote)
+	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// TODO: add TLS
-	go s.Serve(listener)
-
-	signal.Notify(ch, unix.SIGPWR)
-	signal.Notify(ch, unix.SIGINT)
-	signal.Notify(ch, unix.SIGQUIT)
-	signal.Notify(ch, unix.SIGTERM)
-
-	sig := <-ch
-	log.Println("shutting down on signal:", sig)
-
-	listener.Close()
-	s.Shutdown(context.Background())
-	log.Println("clossing application")
-	dq.Close()
-	log.Println("application has shut down")
-
-	return nil
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var m server.Maintenance
+	return &m, errors.Wrap(json.NewDecoder(resp.Body).Decode(&m), "invalid maintenance response")
 }
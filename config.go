@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// configDir is the directory, within the user's config directory, holding the config file
+	configDir = "dqlited"
+
+	// noContext annotates the commands that are not cluster clients, e.g.,
+	// server and config, so contexts (even a stale one) don't apply to them
+	noContext = "no-context"
+)
+
+// Config holds the named contexts used to reach clusters
+type Config struct {
+	CurrentContext string              `yaml:"current-context,omitempty"`
+	Contexts       map[string]*Context `yaml:"contexts"`
+}
+
+// Context holds the settings for one cluster, used as the defaults
+// for flags that are not given on the command line
+type Context struct {
+	Cluster   []string      `yaml:"cluster,omitempty"`
+	Database  string        `yaml:"database,omitempty"`
+	Cert      string        `yaml:"cert,omitempty"`
+	Key       string        `yaml:"key,omitempty"`
+	Timeout   time.Duration `yaml:"timeout,omitempty"`
+	Format    string        `yaml:"format,omitempty"`
+	NodeCache string        `yaml:"node-cache,omitempty"` // defaults to the context name
+	Discover  string        `yaml:"discover,omitempty"`   // e.g., dns:srv:_dqlite._tcp.example.com
+}
+
+// defaultConfigFile returns the path of the config file, e.g., ~/.config/dqlited/config.yaml
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, configDir, "config.yaml")
+}
+
+// readConfig returns the config saved in the file, or an empty one if there is none
+func readConfig(fileName string) (*Config, error) {
+	cfg := &Config{Contexts: make(map[string]*Context)}
+	if fileName == "" {
+		return cfg, nil
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, errors.Wrapf(err, "invalid config file: %s", fileName)
+	}
+	if cfg.Contexts == nil {
+		cfg.Contexts = make(map[string]*Context)
+	}
+	for name, c := range cfg.Contexts {
+		if c == nil {
+			// an entry with no settings, e.g., "prod:"
+			cfg.Contexts[name] = &Context{}
+		}
+	}
+	return cfg, nil
+}
+
+// save writes the config to the file
+func (cfg *Config) save(fileName string) error {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, b, 0600)
+}
+
+// Names returns the names of the contexts, sorted
+func (cfg *Config) Names() []string {
+	names := make([]string, 0, len(cfg.Contexts))
+	for name := range cfg.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Context returns the named context, or the current one if name is blank.
+// It returns nil if no context is named and there is no current one.
+func (cfg *Config) Context(name string) (*Context, string, error) {
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	if name == "" {
+		return nil, "", nil
+	}
+	c, ok := cfg.Contexts[name]
+	if !ok {
+		return nil, name, fmt.Errorf("no such context: %s (have: %s)", name, strings.Join(cfg.Names(), ", "))
+	}
+	return c, name, nil
+}
+
+// apply sets the flags not given on the command line from the context,
+// so flags override the context, which overrides DQLITED_* variables
+func (c *Context) apply(name string, flags *pflag.FlagSet) error {
+	set := func(flag, value string) error {
+		f := flags.Lookup(flag)
+		if f == nil || f.Changed || value == "" {
+			return nil
+		}
+		return errors.Wrapf(f.Value.Set(value), "context %s has invalid %s", name, flag)
+	}
+	if discover == "" {
+		discover = c.Discover
+	}
+	// discovery takes the place of the cluster addresses
+	if discover == "" {
+		if err := set("cluster", strings.Join(c.Cluster, ",")); err != nil {
+			return err
+		}
+	}
+	if err := set("database", c.Database); err != nil {
+		return err
+	}
+	if c.Timeout > 0 {
+		if err := set("timeout", c.Timeout.String()); err != nil {
+			return err
+		}
+	}
+	// commands that pick a format by other means (e.g., export) have no default
+	if f := flags.Lookup("format"); f != nil && f.DefValue == defaultFormat {
+		if err := set("format", c.Format); err != nil {
+			return err
+		}
+	}
+	if c.Cert != "" {
+		globalKeys = KeyPair{Cert: c.Cert, Key: c.Key}
+	}
+	if nodeCache == "" {
+		nodeCache = name
+		if c.NodeCache != "" {
+			nodeCache = c.NodeCache
+		}
+	}
+	return nil
+}
+
+// usesContext reports whether the command is a client that contexts apply to
+func usesContext(cmd *cobra.Command) bool {
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if _, ok := cmd.Annotations[noContext]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// applyConfig applies the named (or current) context of the config file to the command flags
+func applyConfig(fileName, name string, flags *pflag.FlagSet) error {
+	cfg, err := readConfig(fileName)
+	if err != nil {
+		return err
+	}
+	c, name, err := cfg.Context(name)
+	if c == nil || err != nil {
+		return err
+	}
+	return c.apply(name, flags)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// clientFlags returns the flags of a client command parsed from args,
+// with defaults standing in for DQLITED_* variables
+func clientFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	t.Helper()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("cluster", []string{"127.0.0.1:9999"}, "")
+	flags.String("database", "envdb", "")
+	flags.Duration("timeout", time.Minute, "")
+	flags.String("format", defaultFormat, "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestContextPrecedence(t *testing.T) {
+	defer func(n string, k KeyPair) { nodeCache, globalKeys = n, k }(nodeCache, globalKeys)
+	prod := &Context{
+		Cluster:  []string{"10.0.0.1:9181", "10.0.0.2:9181"},
+		Database: "proddb",
+		Timeout:  5 * time.Second,
+		Format:   "json",
+	}
+	tests := []struct {
+		name    string
+		context *Context
+		args    []string
+		want    map[string]string
+	}{
+		{"context over env", prod, nil, map[string]string{
+			"cluster":  "[10.0.0.1:9181,10.0.0.2:9181]",
+			"database": "proddb",
+			"timeout":  "5s",
+			"format":   "json",
+		}},
+		{"flags over context", prod, []string{"--cluster", "127.0.0.1:9181", "--database", "flagdb", "--format", "csv"}, map[string]string{
+			"cluster":  "[127.0.0.1:9181]",
+			"database": "flagdb",
+			"timeout":  "5s",
+			"format":   "csv",
+		}},
+		{"env without context settings", &Context{}, nil, map[string]string{
+			"cluster":  "[127.0.0.1:9999]",
+			"database": "envdb",
+			"timeout":  "1m0s",
+			"format":   defaultFormat,
+		}},
+	}
+	for _, test := range tests {
+		nodeCache = ""
+		flags := clientFlags(t, test.args...)
+		if err := test.context.apply("prod", flags); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for flag, want := range test.want {
+			if got := flags.Lookup(flag).Value.String(); got != want {
+				t.Errorf("%s: got %s %s, want %s", test.name, flag, got, want)
+			}
+		}
+		if nodeCache != "prod" {
+			t.Errorf("%s: got node cache %q, want the context name", test.name, nodeCache)
+		}
+	}
+}
+
+func TestContextInvalid(t *testing.T) {
+	defer func(n string) { nodeCache = n }(nodeCache)
+	c := &Context{Cluster: []string{"127.0.0.1:9181"}}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Int("cluster", 0, "")
+	if err := c.apply("prod", flags); err == nil {
+		t.Error("expected an error for an invalid setting")
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a missing file is an empty config
+	fileName := filepath.Join(dir, "config.yaml")
+	cfg, err := readConfig(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _, err := cfg.Context(""); c != nil || err != nil {
+		t.Errorf("got context %v (%v), want none", c, err)
+	}
+
+	text := "current-context: prod\ncontexts:\n  prod:\n    cluster: [10.0.0.1:9181]\n  dev:\n"
+	if err := ioutil.WriteFile(fileName, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = readConfig(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Names(), []string{"dev", "prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got contexts %v, want %v", got, want)
+	}
+	c, name, err := cfg.Context("")
+	if err != nil || name != "prod" || !reflect.DeepEqual(c.Cluster, []string{"10.0.0.1:9181"}) {
+		t.Errorf("got current context %s %v (%v), want prod", name, c, err)
+	}
+	// an entry with no settings is an empty context
+	if c, _, err := cfg.Context("dev"); c == nil || err != nil {
+		t.Errorf("got dev context %v (%v), want an empty one", c, err)
+	}
+	if _, _, err := cfg.Context("test"); err == nil {
+		t.Error("expected an error for a missing context")
+	}
+}
+
+func TestContextDiscovery(t *testing.T) {
+	defer func(d, n string) { discover, nodeCache = d, n }(discover, nodeCache)
+	tests := []struct {
+		name     string
+		context  *Context
+		discover string // from --discover or DQLITED_DISCOVER
+		cluster  string
+		want     string
+	}{
+		{"context cluster", &Context{Cluster: []string{"10.0.0.1:9181"}}, "", "[10.0.0.1:9181]", ""},
+		{"context discovery over its cluster", &Context{Cluster: []string{"10.0.0.1:9181"}, Discover: "dns:srv:prod"}, "", "[127.0.0.1:9999]", "dns:srv:prod"},
+		{"discover flag over context", &Context{Discover: "dns:srv:prod"}, "dns:srv:flag", "[127.0.0.1:9999]", "dns:srv:flag"},
+		{"discover flag over context cluster", &Context{Cluster: []string{"10.0.0.1:9181"}}, "dns:srv:flag", "[127.0.0.1:9999]", "dns:srv:flag"},
+	}
+	for _, test := range tests {
+		discover = test.discover
+		flags := clientFlags(t)
+		if err := test.context.apply("prod", flags); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := flags.Lookup("cluster").Value.String(); got != test.cluster {
+			t.Errorf("%s: got cluster %s, want %s", test.name, got, test.cluster)
+		}
+		if discover != test.want {
+			t.Errorf("%s: got discover %q, want %q", test.name, discover, test.want)
+		}
+	}
+}
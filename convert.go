@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// dumpReader reads the statements of a database dump from another
+// database, which may quote text in ways that sqlite does not
+type dumpReader struct {
+	r         *bufio.Reader
+	dollar    bool // postgres $tag$ quoting
+	backslash bool // mysql backslash escapes within strings
+}
+
+func newDumpReader(r io.Reader) *dumpReader {
+	return &dumpReader{r: bufio.NewReader(r)}
+}
+
+// next returns the next statement, without comments or its terminating ";",
+// and io.EOF when there are no more
+func (d *dumpReader) next() (string, error) {
+	var buf strings.Builder
+	for {
+		c, _, err := d.r.ReadRune()
+		if err == io.EOF {
+			if s := strings.TrimSpace(buf.String()); s != "" {
+				return s, nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '-':
+			if d.peek('-') {
+				d.readLine()
+				buf.WriteRune('\n')
+				continue
+			}
+			buf.WriteRune(c)
+		case '/':
+			if d.peek('*') {
+				// includes mysql's /*!40101 ... */ version comments
+				d.skipComment()
+				buf.WriteRune(' ')
+				continue
+			}
+			buf.WriteRune(c)
+		case '\'', '"':
+			buf.WriteRune(c)
+			err = d.quoted(&buf, c, d.backslash)
+		case '`':
+			buf.WriteRune(c)
+			err = d.quoted(&buf, c, false)
+		case '$':
+			if d.dollar {
+				err = d.dollarQuoted(&buf)
+				break
+			}
+			buf.WriteRune(c)
+		case ';':
+			if s := strings.TrimSpace(buf.String()); s != "" {
+				return s, nil
+			}
+			buf.Reset()
+			continue
+		default:
+			buf.WriteRune(c)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// readLine returns the remainder of the current line, without the newline
+func (d *dumpReader) readLine() (string, error) {
+	line, err := d.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// peek consumes the next rune if it matches
+func (d *dumpReader) peek(want rune) bool {
+	c, _, err := d.r.ReadRune()
+	if err != nil {
+		return false
+	}
+	if c != want {
+		d.r.UnreadRune()
+		return false
+	}
+	return true
+}
+
+func (d *dumpReader) skipComment() {
+	for {
+		c, _, err := d.r.ReadRune()
+		if err != nil {
+			return
+		}
+		if c == '*' && d.peek('/') {
+			return
+		}
+	}
+}
+
+// quoted copies text through the closing quote, where a doubled quote
+// is an escaped one, as is one preceded by a backslash if allowed
+func (d *dumpReader) quoted(buf *strings.Builder, end rune, backslash bool) error {
+	for {
+		c, _, err := d.r.ReadRune()
+		if err != nil {
+			return errors.Wrap(err, "unterminated quote")
+		}
+		buf.WriteRune(c)
+		switch {
+		case c == '\\' && backslash:
+			c, _, err = d.r.ReadRune()
+			if err != nil {
+				return errors.Wrap(err, "unterminated quote")
+			}
+			buf.WriteRune(c)
+		case c == end:
+			if !d.peek(end) {
+				return nil
+			}
+			buf.WriteRune(end)
+		}
+	}
+}
+
+// dollarQuoted copies a postgres $tag$...$tag$ string, the opening "$"
+// having been read. A "$" that doesn't start a tag is copied as is.
+func (d *dumpReader) dollarQuoted(buf *strings.Builder) error {
+	var tag strings.Builder
+	tag.WriteRune('$')
+	for {
+		c, _, err := d.r.ReadRune()
+		if err != nil {
+			buf.WriteString(tag.String())
+			return nil
+		}
+		if c == '$' {
+			break
+		}
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			buf.WriteString(tag.String())
+			d.r.UnreadRune()
+			return nil
+		}
+		tag.WriteRune(c)
+	}
+	tag.WriteRune('$')
+	delim := tag.String()
+	buf.WriteString(delim)
+	var body strings.Builder
+	for !strings.HasSuffix(body.String(), delim) {
+		c, _, err := d.r.ReadRune()
+		if err != nil {
+			return errors.Wrapf(err, "unterminated %s quote", delim)
+		}
+		body.WriteRune(c)
+	}
+	buf.WriteString(body.String())
+	return nil
+}
+
+// fields splits text on whitespace, keeping quoted and parenthesized text intact
+func fields(text string) []string {
+	var list []string
+	var quote rune
+	depth, start := 0, -1
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case unicode.IsSpace(c) && depth == 0:
+			if start >= 0 {
+				list = append(list, text[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		list = append(list, text[start:])
+	}
+	return list
+}
+
+// splitList splits text at the commas outside of quotes and parentheses
+func splitList(text string) []string {
+	var list []string
+	var quote rune
+	depth, start := 0, 0
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			list = append(list, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(text[start:]); rest != "" {
+		list = append(list, rest)
+	}
+	return list
+}
+
+// parenthesized returns the text within the first pair of parentheses
+// and whatever follows them
+func parenthesized(text string) (inner, rest string, ok bool) {
+	open := strings.IndexByte(text, '(')
+	if open < 0 {
+		return "", text, false
+	}
+	var quote rune
+	depth := 0
+	for i, c := range text[open:] {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				end := open + i
+				return text[open+1 : end], strings.TrimSpace(text[end+1:]), true
+			}
+		}
+	}
+	return "", text, false
+}
+
+// hasPrefixFold reports whether the statement starts with the given
+// keywords, ignoring case and the amount of whitespace between them
+func hasPrefixFold(statement string, keywords ...string) bool {
+	words := strings.Fields(statement)
+	if len(words) < len(keywords) {
+		return false
+	}
+	for i, keyword := range keywords {
+		if !strings.EqualFold(words[i], keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+// spool holds the converted data until the schema is complete, as dumps
+// can define constraints after the data they apply to has been copied
+type spool struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newSpool() (*spool, error) {
+	f, err := ioutil.TempFile("", "dqlited-convert")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create spool file")
+	}
+	return &spool{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// copyTo writes the spooled text to w
+func (s *spool) copyTo(w io.Writer) error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, s.f)
+	return err
+}
+
+func (s *spool) Close() error {
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
+
+// converter translates a dump from another database into a script for load
+type converter interface {
+	convert(r io.Reader, w io.Writer) error
+}
+
+// convertFile converts the input file ("-" for stdin) to the output file (stdout if blank)
+func convertFile(c converter, input, output string) error {
+	r, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return errors.Wrapf(err, "can't create output file: %s", output)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := c.convert(r, bw); err != nil {
+		return errors.Wrapf(err, "can't convert file: %s", input)
+	}
+	return bw.Flush()
+}
+
+// skipped notes a statement that has no sqlite equivalent
+func skipped(verbose bool, statement string) {
+	if !verbose {
+		return
+	}
+	if i := strings.IndexByte(statement, '\n'); i > 0 {
+		statement = statement[:i] + " ..."
+	}
+	log.Println("skipping:", statement)
+}
+
+// convertHeader begins the converted script
+func convertHeader(w io.Writer, source string, skipped int) {
+	fmt.Fprintf(w, "--\n-- Converted from a %s dump by dqlited\n", source)
+	if skipped > 0 {
+		fmt.Fprintf(w, "-- %d statements without a sqlite equivalent were skipped\n", skipped)
+	}
+	fmt.Fprintln(w, "--")
+}
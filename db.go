@@ -3,43 +3,31 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"database/sql"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
-	"text/tabwriter"
 	"time"
 
-	app "github.com/canonical/go-dqlite/app"
 	"github.com/canonical/go-dqlite/client"
 	"github.com/canonical/go-dqlite/driver"
-	"github.com/paulstuart/dbreaker"
+	"github.com/paulstuart/dqlited/script"
+	"github.com/paulstuart/dqlited/server"
 	"github.com/pkg/errors"
 )
 
 var (
-	dbxMu        sync.Mutex
-	dbxDB        = make(map[string]*sql.DB)
 	dbxDisableMu sync.Mutex // circuit breaker switch
 	dbxDisabled  bool       // circuit breaker switch
 
 	// ErrDatabaseUnavailable is returned if the database is unavailable
 	ErrDatabaseUnavailable = errors.New("database is unavailable")
-
-	breaker dbreaker.Downer
 )
 
-func init() {
-	breaker, _ = dbreaker.NewDriver("dbreaker", "dqlite")
-}
-
 // EnableDatabase controls the database circuit breaker
 func EnableDatabase(enable bool) {
 	dbxDisableMu.Lock()
@@ -54,68 +42,39 @@ func DatabaseDisabled() bool {
 	return dbxDisabled
 }
 
+// QDB renders the results of a query
 type QDB interface {
 	Header([]string)
 	Body([]interface{})
 	Close()
 }
 
-type DBTW struct {
-	tw    *tabwriter.Writer
-	multi bool
-}
-
-func (d *DBTW) Header(columns []string) {
-	if d.multi {
-		d.tw.Flush()
-	}
-	d.multi = true
-	for i, column := range columns {
-		if i > 0 {
-			fmt.Fprint(d.tw, "\t")
-		}
-		fmt.Fprint(d.tw, column)
-	}
-	fmt.Fprintln(d.tw)
-	for i, column := range columns {
-		if i > 0 {
-			fmt.Fprint(d.tw, "\t")
-		}
-		fmt.Fprint(d.tw, strings.Repeat("-", len(column)))
-	}
-}
-
-func (d *DBTW) Body(buffer []interface{}) {
-	for i, column := range buffer {
-		if i > 0 {
-			fmt.Fprint(d.tw, "\t")
-		}
-		fmt.Fprint(d.tw, column)
-	}
-	fmt.Fprintln(d.tw)
-}
-
-func (d *DBTW) Close() {
-	d.tw.Flush()
-}
-
-var _ QDB = (*DBTW)(nil)
-
+// queryer streams the results of the statement to the renderer
 func (dx *DBX) queryer(qdb QDB, statement string, args ...interface{}) error {
 	if DatabaseDisabled() {
 		return ErrDatabaseUnavailable
 	}
-	rows, err := dx.db.Query(statement)
+	rows, err := dx.db.Query(statement, args...)
 	if err != nil {
 		return errors.Wrap(err, "dbx query failed")
 	}
+	return dx.render(qdb, rows)
+}
 
+// render writes each result set of the rows to qdb
+func (dx *DBX) render(qdb QDB, rows *sql.Rows) error {
+	defer rows.Close()
 	defer qdb.Close()
 	for {
 		columns, err := rows.Columns()
 		if err != nil {
 			return err
 		}
+		if dx.types {
+			if columns, err = typedColumns(rows, columns); err != nil {
+				return err
+			}
+		}
 
 		qdb.Header(columns)
 
@@ -128,7 +87,10 @@ func (dx *DBX) queryer(qdb QDB, statement string, args ...interface{}) error {
 			if err := rows.Scan(scanTo...); err != nil {
 				return errors.Wrap(err, "failed to scan row")
 			}
-			qdb.Body(scanTo)
+			qdb.Body(buffer)
+		}
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "failed reading rows")
 		}
 		if !rows.NextResultSet() {
 			break
@@ -138,78 +100,45 @@ func (dx *DBX) queryer(qdb QDB, statement string, args ...interface{}) error {
 	return nil
 }
 
-func (dx *DBX) query(statement string, args ...interface{}) error {
-	if DatabaseDisabled() {
-		return ErrDatabaseUnavailable
+// typedColumns annotates the column names with their declared types
+func typedColumns(rows *sql.Rows, columns []string) ([]string, error) {
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, errors.Wrap(err, "column types fail")
+	}
+	typed := make([]string, len(columns))
+	for i, column := range columns {
+		typed[i] = column
+		if name := colTypes[i].DatabaseTypeName(); name != "" {
+			typed[i] += ":" + name
+		}
 	}
+	return typed, nil
+}
+
+// query prints the results of the statement in the current format
+func (dx *DBX) query(statement string, args ...interface{}) error {
 	if dx.w == nil {
 		dx.w = os.Stdout
 	}
-	rows, err := dx.db.Query(statement)
+	qdb, err := NewRenderer(dx.mode(), dx.w, RenderOptions{Header: dx.header, Dividers: dx.lines})
 	if err != nil {
-		return errors.Wrap(err, "dbx query failed")
-	}
-	defer rows.Close()
-	flags := tabwriter.TabIndent
-	if dx.lines {
-		flags |= tabwriter.Debug
+		return err
 	}
+	return dx.queryer(qdb, statement, args...)
+}
 
-	for {
-		// tabwriter args: output, minwidth, tabwidth, padding, padchar, flags
-		tw := tabwriter.NewWriter(
-			dx.w,  // io.Writer
-			0,     // min width
-			0,     // tab width
-			1,     // padding
-			' ',   // pad character
-			flags, // behavior flags
-		)
-		columns, _ := rows.Columns()
-
-		if dx.header {
-			for i, column := range columns {
-				if i > 0 {
-					fmt.Fprint(tw, "\t")
-				}
-				fmt.Fprint(tw, column)
-			}
-			fmt.Fprintln(tw)
-			for i, column := range columns {
-				if i > 0 {
-					fmt.Fprint(tw, "\t")
-				}
-				fmt.Fprint(tw, strings.Repeat("-", len(column)))
-			}
-			fmt.Fprintln(tw)
-		}
-
-		buffer := make([]interface{}, len(columns))
-		scanTo := make([]interface{}, len(columns))
-		for i := range buffer {
-			scanTo[i] = &buffer[i]
-		}
-		for rows.Next() {
-			if err := rows.Scan(scanTo...); err != nil {
-				tw.Flush()
-				return errors.Wrap(err, "failed to scan row")
-			}
-			for i, column := range buffer {
-				if i > 0 {
-					fmt.Fprint(tw, "\t")
-				}
-				fmt.Fprint(tw, column)
-			}
-			fmt.Fprintln(tw)
-		}
-		tw.Flush()
-
-		if !rows.NextResultSet() {
-			break
-		}
+// queryTx prints the results of the statement, run within the transaction
+func (dx *DBX) queryTx(tx *sql.Tx, statement string) error {
+	qdb, err := NewRenderer(dx.mode(), dx.w, RenderOptions{Header: dx.header, Dividers: dx.lines})
+	if err != nil {
+		return err
 	}
-
-	return nil
+	rows, err := tx.Query(statement)
+	if err != nil {
+		return errors.Wrap(err, "dbx query failed")
+	}
+	return dx.render(qdb, rows)
 }
 
 // run a query with a single column result and return the value of same
@@ -221,7 +150,7 @@ func queryColumn(db *sql.DB, statement string, args ...interface{}) (string, err
 }
 
 // run a single command and print its results
-func dbCmd(ctx context.Context, kp *KeyPair, dbName string, cluster []string, logger client.LogFunc, header, divs bool, statement string) error {
+func dbCmd(ctx context.Context, kp *KeyPair, dbName string, cluster []string, logger client.LogFunc, format string, header, divs bool, statement string, args ...interface{}) error {
 	dx, err := NewConnection(ctx, kp, dbName, cluster, logger)
 	if err != nil {
 		return err
@@ -229,88 +158,27 @@ func dbCmd(ctx context.Context, kp *KeyPair, dbName string, cluster []string, lo
 	defer dx.Close()
 	dx.header = header
 	dx.lines = divs
-	return dx.Eval(statement)
-}
-
-func getDB(ctx context.Context, pair *KeyPair, dbName string, cluster []string, logger client.LogFunc) (*sql.DB, error) {
-	dbxMu.Lock()
-	defer dbxMu.Unlock()
-	if db, ok := dbxDB[dbName]; ok {
-		return db, nil
-	}
-	store := getStore(ctx, cluster)
-	if len(dbxDB) == 0 {
-		if logger == nil {
-			logger = client.DefaultLogFunc
-		}
-		dial := client.DefaultDialFunc
-		opts := []driver.Option{driver.WithLogFunc(logger)}
-		//pair := globalKeys
-		if pair != nil && pair.Cert != "" {
-			cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
-			if err != nil {
-				return nil, err
-			}
-
-			data, err := ioutil.ReadFile(pair.Cert)
-			if err != nil {
-				return nil, err
-			}
-
-			pool := x509.NewCertPool()
-			if !pool.AppendCertsFromPEM(data) {
-				return nil, fmt.Errorf("bad certificate")
-			}
-			config := app.SimpleDialTLSConfig(cert, pool)
-			dial = client.DialFuncWithTLS(dial, config)
-			log.Println("connecting with TLS")
-		}
-		opts = append(opts, driver.WithDialFunc(dial))
-
-		dbDriver, err := driver.New(store, opts...)
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to create dqlite driver")
-		}
-		sql.Register("dqlite", dbDriver)
-	}
-
-	db, err := sql.Open("dqlite", dbName)
-	if err == nil {
-		dbxDB[dbName] = db
+	if err := dx.setMode(format); err != nil {
+		return err
 	}
-	db.SetMaxOpenConns(1) // dqlite is single-threaded
-	return db, err
+	return dx.Eval(statement, args...)
 }
 
-// Eval executes a single read/write query
-func (dx *DBX) Eval(statement string) error {
+// Eval executes a single read/write query, binding any args given
+func (dx *DBX) Eval(statement string, args ...interface{}) error {
 	if statement == "" {
 		return fmt.Errorf("no statements given")
 	}
-	// TODO: add additional sqlite cli commands
-	switch statement {
-	case ".schema":
-		statement = "select sql || ';' from sqlite_master"
-	case ".tables":
-		statement = "select name from sqlite_master where type='table' order by name"
+	if strings.HasPrefix(statement, ".") {
+		return dx.dotCommand(statement)
 	}
 
-	action := strings.ToUpper(strings.Fields(statement)[0])
-	switch action {
-	case "SELECT":
-		return errors.Wrapf(dx.query(statement), "dx.Eval query failed: %q", statement)
-	case "PRAGMA":
-		// some pragmas write, others read...
-		if strings.Contains(statement, "=") {
-			_, err := dx.exec(statement)
-			return errors.Wrapf(err, "dx.Eval pragma exec failed: %q", statement)
-		}
-		return errors.Wrapf(dx.query(statement), "dx.Eval pragma query failed: %q", statement)
-	default:
-		// Everything else is writing, e.g., INSERT, UPDATE, DELETE
-		_, err := dx.exec(statement)
-		return errors.Wrapf(err, "dx.Eval exec fail (%T): %q", err, statement)
+	if script.Classify(statement).Rows {
+		return errors.Wrapf(dx.query(statement, args...), "dx.Eval query failed: %q", statement)
 	}
+	// Everything else is writing, e.g., INSERT, UPDATE, DELETE
+	_, err := dx.exec(statement, args...)
+	return errors.Wrapf(err, "dx.Eval exec fail (%T): %q", err, statement)
 }
 
 // execute a write statement against the database
@@ -356,28 +224,29 @@ type SqliteError interface {
 // DBX is the database handler used by dqlited
 type DBX struct {
 	db      *sql.DB
+	key     connKey // of the shared connection
 	name    string
 	w       io.Writer
+	format  string
 	header  bool
 	lines   bool
+	types   bool
 	verbose bool
+	rejects *Rejects // records failed statements instead of stopping
 }
 
 // NewConnection return a db connection
 func NewConnection(ctx context.Context, kp *KeyPair, dbName string, cluster []string, logger LogFunc) (*DBX, error) {
-	db, err := getDB(ctx, kp, dbName, cluster, logger)
+	db, key, err := getDB(ctx, kp, dbName, cluster, logger)
 	if err != nil {
 		return nil, err
 	}
-	return &DBX{db: db, name: dbName, w: os.Stdout, header: true}, nil
+	return &DBX{db: db, key: key, name: dbName, w: os.Stdout, format: defaultFormat, header: true}, nil
 }
 
-// Close will close the open database connection
+// Close releases the database connection, closing it if no other is sharing it
 func (dx *DBX) Close() error {
-	dbxMu.Lock()
-	defer dbxMu.Unlock()
-	delete(dbxDB, dx.name)
-	return dx.db.Close()
+	return releaseDB(dx.key)
 }
 
 // QueryRows returns the rows of a query
@@ -387,69 +256,30 @@ func (dx *DBX) QueryRows(query string, args ...interface{}) ([]Rows, error) {
 	}
 	log.Printf("QUERY: %s ARGS: %v\n", query, args)
 	reply := make([]Rows, 0, 32)
-	action := strings.ToUpper(strings.Fields(query)[0])
-	if action != "SELECT" && action != "PRAGMA" {
-		return nil, fmt.Errorf("Invalid action: %q -- must use SELECT", action)
+	if info := script.Classify(query); !info.ReadOnly || !info.Rows {
+		return nil, fmt.Errorf("invalid query: %q -- must be a read-only statement that returns rows", query)
 	}
-	rows, err := dx.db.Query(query)
+	rows, err := dx.db.Query(query, args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "query failed")
 	}
 	defer rows.Close()
-	var resp Rows
-	resp.Columns, _ = rows.Columns()
-	resp.Types = make([]string, len(resp.Columns))
-	colTypes, err := rows.ColumnTypes()
+	resp, err := server.ScanRows(rows)
 	if err != nil {
-		return nil, errors.Wrap(err, "column types fail")
-	}
-	for i, colType := range colTypes {
-		//fmt.Printf("COL TYPES (%d): %q SCAN: %v\n", i, colType.DatabaseTypeName(), colType.ScanType())
-		resp.Types[i] = colType.DatabaseTypeName()
-	}
-	// TODO: add support for NextResultSet()
-	for rows.Next() {
-		// TODO: optimize by scanning directly into new resp.Rows
-		//buffer := make([]string, len(resp.Columns))
-		buffer := make([]interface{}, len(resp.Columns))
-		scanTo := make([]interface{}, len(buffer))
-		for i := range buffer {
-			scanTo[i] = &buffer[i]
-		}
-		if err := rows.Scan(scanTo...); err != nil {
-			return nil, errors.Wrap(err, "failed to scan row")
-		}
-		fmt.Printf("SCANNED: %v\n", buffer)
-		resp.Values = append(resp.Values, buffer)
+		return nil, err
 	}
 	reply = append(reply, resp)
 	return reply, nil
 }
 
 // Result is the results of a database execution
-// used by the web api for the python DBI adapter
-type Result struct {
-	LastInsertID int64   `json:"last_insert_id,omitempty"`
-	RowsAffected int64   `json:"rows_affected,omitempty"`
-	Error        string  `json:"error,omitempty"`
-	Time         float64 `json:"time,omitempty"`
-}
+type Result = server.Result
 
-// Rows represents the outcome of an operation that returns query data.
-// used by the web api for the python DBI adapter
-type Rows struct {
-	Columns []string        `json:"columns,omitempty"`
-	Types   []string        `json:"types,omitempty"`
-	Values  [][]interface{} `json:"values,omitempty"`
-	Error   string          `json:"error,omitempty"`
-	Time    float64         `json:"time,omitempty"`
-}
+// Rows represents the outcome of an operation that returns query data
+type Rows = server.Rows
 
 // ExecuteResponse is the response used by pydqlite
-type ExecuteResponse struct {
-	Results []Result `json:"results,omitempty"`
-	Time    float64  `json:"time,omitempty"`
-}
+type ExecuteResponse = server.ExecuteResponse
 
 // Executor interface abstracts database execution
 type Executor interface {
@@ -471,17 +301,14 @@ func (dx *DBX) Execute(statements ...string) (*ExecuteResponse, error) {
 	results := make([]Result, 0, len(statements))
 
 	for i, statement := range statements {
-		resp, err := dx.db.Exec(statement)
+		result, err := server.ExecuteStatement(context.Background(), dx.db, statement)
 		if err != nil {
 			log.Printf("EXEC FAIL FOR: %q -- %v\n", statement, err)
 			return nil, errors.Wrapf(err, "DBX.Execute fail (%d/%d): %q", i+1, len(statements), statement)
 		}
-		lastID, _ := resp.LastInsertId()
-		affected, _ := resp.RowsAffected()
 		if dx.verbose {
-			log.Printf("EXEC OK (%d): %s\n", affected, statement)
+			log.Printf("EXEC OK (%d): %s\n", result.RowsAffected, statement)
 		}
-		result := Result{LastInsertID: lastID, RowsAffected: affected}
 		results = append(results, result)
 	}
 
@@ -502,17 +329,14 @@ func MakeExec(db *sql.DB, verbose bool) DBFunc {
 		results := make([]Result, 0, len(statements))
 
 		for i, statement := range statements {
-			resp, err := db.ExecContext(ctx, statement)
+			result, err := server.ExecuteStatement(ctx, db, statement)
 			if err != nil {
 				log.Printf("EXEC FAIL FOR: %q -- %v\n", statement, err)
 				return nil, errors.Wrapf(err, "DBX.Execute fail (%d/%d): %q", i+1, len(statements), statement)
 			}
-			lastID, _ := resp.LastInsertId()
-			affected, _ := resp.RowsAffected()
 			if verbose {
-				log.Printf("EXEC OK (%d): %s\n", affected, statement)
+				log.Printf("EXEC OK (%d): %s\n", result.RowsAffected, statement)
 			}
-			result := Result{LastInsertID: lastID, RowsAffected: affected}
 			results = append(results, result)
 		}
 
@@ -585,37 +409,70 @@ func _dbDump(ctx context.Context, pair *KeyPair, dbname string, cluster []string
 }
 
 // for one-shot file loads
-func dbFile(ctx context.Context, kp *KeyPair, filename, dbname string, batched, verbose bool, cluster []string) error {
+func dbFile(ctx context.Context, kp *KeyPair, filenames []string, dbname string, opts LoadOptions, verbose bool, cluster []string) error {
 	dx, err := NewConnection(ctx, kp, dbname, cluster, nil)
 	if err != nil {
-		return err
+		return withExit(exitConnect, err)
 	}
 	defer dx.Close()
 	dx.verbose = verbose
-	defer dx.db.Close()
-	return dx.loadFile(filename, batched)
+	if opts.Rejects != "" {
+		if dx.rejects, err = NewRejects(opts.Rejects); err != nil {
+			return err
+		}
+		defer dx.rejects.Close()
+	}
+	if opts.Chunk < 1 && opts.Checkpoint == "" {
+		for _, filename := range filenames {
+			if dx.rejects != nil {
+				dx.rejects.Source = filename
+			}
+			if err := dx.loadFile(filename, opts.Batch); err != nil {
+				return err
+			}
+		}
+	} else {
+		stats, err := dx.loadChunks(filenames, opts)
+		if stats != nil {
+			fmt.Println(stats)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if dx.rejects != nil && dx.rejects.Count > 0 {
+		return withExit(exitRejected, fmt.Errorf("%d statements failed -- see %s", dx.rejects.Count, opts.Rejects))
+	}
+	return nil
 }
 
 // for one-shot process file with multiple queries
-func dbReport(ctx context.Context, kp *KeyPair, filename, dbname string, header, lines bool, cluster []string) error {
+func dbReport(ctx context.Context, kp *KeyPair, filename, dbname, format string, header, lines bool, cluster []string, args ...interface{}) error {
 	dx, err := NewConnection(ctx, kp, dbname, cluster, nil)
 	if err != nil {
-		return err
+		return withExit(exitConnect, err)
 	}
 	dx.header = header
 	dx.lines = lines
-	defer dx.db.Close()
-	return dx.queryFile(filename)
+	if err := dx.setMode(format); err != nil {
+		return withExit(exitUsage, err)
+	}
+	defer dx.Close()
+	return dx.queryFile(filename, args...)
 }
 
-// a line generator for strings
-func lister(args ...string) chan string {
+// a line generator for strings, which stops early if done is closed
+func lister(done <-chan struct{}, args ...string) chan string {
 	c := make(chan string)
 	go func() {
+		defer close(c)
 		for _, arg := range args {
-			c <- arg
+			select {
+			case c <- arg:
+			case <-done:
+				return
+			}
 		}
-		close(c)
 	}()
 	return c
 }
@@ -638,32 +495,53 @@ func liner(r io.ReadCloser) chan string {
 	return c
 }
 
-// loadFile will apply the given file to the current database
+// a statement generator for a Reader, which stops early if done is closed
+func splitter(r io.Reader, done <-chan struct{}) chan string {
+	c := make(chan string)
+	scanner := script.NewStatementScanner(r)
+
+	go func() {
+		defer close(c)
+		for scanner.Scan() {
+			select {
+			case c <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Println("reading statements:", err)
+		}
+	}()
+	return c
+}
+
+// loadFile will apply the given file to the current database,
+// streaming its statements rather than reading it all up front
 func (dx *DBX) loadFile(fileName string, batched bool) error {
 	if dx.verbose {
 		log.Println("loading file:", fileName)
 	}
-	if batched {
-		f, err := os.Open(fileName)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		return transact(dx.db, dx.verbose, liner(f))
-	}
-	buffer, err := ioutil.ReadFile(fileName)
+	r, err := openInput(fileName)
 	if err != nil {
-		return errors.Wrapf(err, "error reading file: %s", fileName)
+		return err
 	}
-	return dx.Batch(string(buffer))
+	defer r.Close()
+	if batched {
+		// stop the splitter if the transaction fails before the end of the file
+		done := make(chan struct{})
+		defer close(done)
+		return errors.Wrapf(transact(dx.db, dx.verbose, splitter(r, done)), "error loading file: %s", fileName)
+	}
+	return errors.Wrapf(dx.BatchReader(r), "error loading file: %s", fileName)
 }
 
-func (dx *DBX) queryFile(fileName string) error {
+func (dx *DBX) queryFile(fileName string, args ...interface{}) error {
 	buffer, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return errors.Wrapf(err, "error reading file: %s", fileName)
 	}
-	return dx.query(string(buffer))
+	return dx.query(string(buffer), args...)
 }
 
 // transact executes the collection of statements as a single transaction
@@ -678,6 +556,16 @@ func transact(db *sql.DB, verbose bool, statements chan string) error {
 	count := 0
 	started := time.Now()
 	for s := range statements {
+		switch script.TxControl(s) {
+		case "BEGIN", "COMMIT":
+			// the statements are already within a transaction
+			continue
+		case "ROLLBACK":
+			// committing the statements before it would be wrong,
+			// and so would silently discarding the whole batch
+			tx.Rollback()
+			return fmt.Errorf("ROLLBACK is not supported in a batched load")
+		}
 		if _, err := tx.Exec(s); err != nil {
 			tx.Rollback()
 			return err
@@ -687,9 +575,10 @@ func transact(db *sql.DB, verbose bool, statements chan string) error {
 			log.Println("success:", count)
 		}
 	}
-	tx.Commit()
-	//if verbose && count > 0 {
-	if count > 0 {
+	if err := tx.Commit(); err != nil {
+		return commitError{err}
+	}
+	if verbose && count > 0 {
 		delta := time.Now().Sub(started)
 		//ns := time.Now().Sub(started).Nanoseconds()
 		rate := time.Duration(delta.Nanoseconds() / int64(count))
@@ -698,191 +587,146 @@ func transact(db *sql.DB, verbose bool, statements chan string) error {
 	return nil
 }
 
-var (
-	commentC   = regexp.MustCompile(`(?s)/\*.*?\*/`)
-	commentSQL = regexp.MustCompile(`\s*--.*`)
-	readline   = regexp.MustCompile(`(\.[a-z]+( .*)*)`)
-)
+// transactList is transact for a list of statements
+func transactList(db *sql.DB, verbose bool, statements []string) error {
+	// stop the lister if the transaction fails before the end of the list
+	done := make(chan struct{})
+	defer close(done)
+	return transact(db, verbose, lister(done, statements...))
+}
+
+// commitError is a failed commit, which may or may not have been applied
+// if the connection was lost before the result came back
+type commitError struct {
+	err error
+}
 
-// startsWith provides a case-insensitive string prefix test
-func startsWith(data, sub string) bool {
-	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(data)), strings.ToUpper(sub))
+func (e commitError) Error() string {
+	return "could not commit transaction: " + e.err.Error()
 }
 
-// CleanText removes C-style and SQL-style comments from the given text
-// which allows for simpler parsing of the contents
-func CleanText(s string) string {
-	clean := commentC.ReplaceAll([]byte(s), []byte{})
-	clean = commentSQL.ReplaceAll(clean, []byte{})
-	return string(clean)
+// Cause returns the underlying error, for errors.Cause
+func (e commitError) Cause() error {
+	return e.err
 }
 
 // Batch emulates the client reading a series of commands,
 // primarily those created from dumping from sqlite.
 //
-// Input is as a string (instead of a Reaader) to allow for easy
-// regexp across multiple lines. For
-//
-// Normally statements are terminated by ";" but this is complicated
-// by trigger statements which include one or more statements with
-// their own ";" occurances between the BEGIN and END
+// Statements are split by a StatementScanner, so semicolons within
+// literals, trigger bodies, and CASE expressions are handled. An explicit
+// BEGIN ... COMMIT in the input is applied as an actual transaction.
 func (dx *DBX) Batch(buffer string) error {
-	w := dx.w
-	if w == nil {
-		w = os.Stdout
-	}
-	// evaluate input on a line by line basis,
-	// (though statements can be multiple lines)
-	lines := strings.Split(CleanText(buffer), "\n")
-	var echo, trigger bool
+	return dx.BatchReader(strings.NewReader(buffer))
+}
+
+// BatchReader is Batch for statements read from r
+func (dx *DBX) BatchReader(r io.Reader) error {
+	scanner := script.NewStatementScanner(r)
 	var err error
-	var statement strings.Builder
 	var tx *sql.Tx
-	prompt := " => "
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	defer func() {
+		// only an error leaves the transaction open
+		if tx != nil {
+			tx.Rollback()
 		}
-		if echo {
-			log.Println(prompt, line)
+	}()
+	for scanner.Scan() {
+		stmt := scanner.Text()
+		// failed statements can be set aside if sqlite rejected them
+		failed := func(err error) error {
+			if dx.rejects != nil && isSqliteError(err) {
+				return dx.rejects.Add(scanner.Line(), stmt, err)
+			}
+			return errors.Wrapf(err, "line %d: %s", scanner.Line(), stmt)
 		}
-		statement.WriteString(line + "\n")
-		switch {
-		case startsWith(line, "BEGIN TRANSACTION"):
-			// skip the BEGIN line since it's implicit in the actual TX
-			statement.Reset()
+		switch script.TxControl(stmt) {
+		case "BEGIN":
 			if dx.verbose {
-				log.Println(line)
+				log.Println(stmt)
+			}
+			if tx != nil {
+				return fmt.Errorf("line %d: transaction already started", scanner.Line())
 			}
 			tx, err = dx.db.Begin()
 			if err != nil {
 				return errors.Wrap(err, "could not create transaction")
 			}
 			continue
-		case startsWith(line, "COMMIT;"):
+		case "COMMIT":
 			if dx.verbose {
-				log.Println(line)
+				log.Println(stmt)
 			}
-			if err := tx.Commit(); err != nil {
-				return errors.Wrap(err, "could not close transaction")
+			if tx == nil {
+				return fmt.Errorf("line %d: commit without a transaction", scanner.Line())
 			}
-			tx = nil
-			statement.Reset()
-			continue
-		case startsWith(line, "CREATE TRIGGER"):
-			if !strings.Contains(line, ";") {
-				trigger = true
-				continue
+			err, tx = tx.Commit(), nil
+			if err != nil {
+				return errors.Wrapf(err, "line %d: could not commit transaction", scanner.Line())
 			}
-		case startsWith(line, "END;"):
-			trigger = false
-		case trigger:
-			log.Println("IN TRIGGER")
 			continue
-		}
-		if !strings.Contains(line, ";") {
+		case "ROLLBACK":
+			if dx.verbose {
+				log.Println(stmt)
+			}
+			if tx == nil {
+				return fmt.Errorf("line %d: rollback without a transaction", scanner.Line())
+			}
+			err, tx = tx.Rollback(), nil
+			if err != nil {
+				return errors.Wrap(err, "could not roll back transaction")
+			}
 			continue
 		}
-		stmt := statement.String()
-		switch {
-		case startsWith(stmt, "SELECT"):
-			dx.query(stmt)
+		// within a transaction everything must go through it, as the
+		// pool has a single connection and the transaction holds it
+		switch rows := script.Classify(stmt).Rows; {
+		case tx != nil && rows:
+			if dx.verbose {
+				log.Println("TX QUERY:", stmt)
+			}
+			if err := dx.queryTx(tx, stmt); err != nil {
+				if err := failed(err); err != nil {
+					return err
+				}
+			}
 		case tx != nil:
 			if dx.verbose {
 				log.Println("TX EXEC:", stmt)
 			}
 			if _, err := tx.Exec(stmt); err != nil {
-				return err
+				if err := failed(err); err != nil {
+					return err
+				}
+			}
+		case rows:
+			if err := dx.query(stmt); err != nil {
+				if err := failed(err); err != nil {
+					return err
+				}
 			}
 		default:
 			if dx.verbose {
 				log.Println("DB EXEC:", stmt)
 			}
 			if _, err := dx.exec(stmt); err != nil {
-				log.Println("EXEC ERR:", err)
-				return errors.Wrapf(err, "EXEC QUERY: %s FILE: %s", line, "mydb")
+				if err := failed(err); err != nil {
+					return err
+				}
 			}
 		}
-		statement.Reset()
-	}
-	return nil
-}
-
-//
-//
-// NEW CODE FOR APP CHANGES IN GO-DQLITE
-//
-//
-// QueryRows returns the rows of a query
-func QueryRows(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Rows, error) {
-	if DatabaseDisabled() {
-		return nil, ErrDatabaseUnavailable
-	}
-	log.Printf("QUERY: %s ARGS: %v\n", query, args)
-	reply := make([]Rows, 0, 32)
-	action := strings.ToUpper(strings.Fields(query)[0])
-	if action != "SELECT" && action != "PRAGMA" {
-		return nil, fmt.Errorf("Invalid action: %q -- must use SELECT", action)
-	}
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, errors.Wrap(err, "query failed")
-	}
-	defer rows.Close()
-
-	var resp Rows
-	resp.Columns, _ = rows.Columns()
-	resp.Types = make([]string, len(resp.Columns))
-	colTypes, err := rows.ColumnTypes()
-	if err != nil {
-		return nil, errors.Wrap(err, "column types fail")
 	}
-	for i, colType := range colTypes {
-		//fmt.Printf("COL TYPES (%d): %q SCAN: %v\n", i, colType.DatabaseTypeName(), colType.ScanType())
-		resp.Types[i] = colType.DatabaseTypeName()
-	}
-	// TODO: add support for NextResultSet()
-	for rows.Next() {
-		buffer := make([]interface{}, len(resp.Columns))
-		scanTo := make([]interface{}, len(buffer))
-		for i := range buffer {
-			scanTo[i] = &buffer[i]
-		}
-		if err := rows.Scan(scanTo...); err != nil {
-			return nil, errors.Wrap(err, "failed to scan row")
-		}
-		fmt.Printf("SCANNED: %v\n", buffer)
-		resp.Values = append(resp.Values, buffer)
-	}
-	reply = append(reply, resp)
-	return reply, nil
-}
-
-// ExecuteContext will execute a series of statements, exec and query
-// TODO: consolidate with Batch?
-func ExecuteContext(ctx context.Context, db *sql.DB, statements ...string) (*ExecuteResponse, error) {
-	if DatabaseDisabled() {
-		return nil, ErrDatabaseUnavailable
+	if err := scanner.Err(); err != nil {
+		return err
 	}
-	started := time.Now()
-	results := make([]Result, 0, len(statements))
-
-	for i, statement := range statements {
-		resp, err := db.ExecContext(ctx, statement)
-		if err != nil {
-			log.Printf("EXEC FAIL FOR: %q -- %v\n", statement, err)
-			return nil, errors.Wrapf(err, "DBX.Execute fail (%d/%d): %q", i+1, len(statements), statement)
-		}
-		lastID, _ := resp.LastInsertId()
-		affected, _ := resp.RowsAffected()
-		if verbose {
-			log.Printf("EXEC OK (%d): %s\n", affected, statement)
+	if tx != nil {
+		// the input ended without closing its transaction, so complete it
+		// rather than silently discarding what was applied
+		if dx.verbose {
+			log.Println("committing transaction left open at end of input")
 		}
-		result := Result{LastInsertID: lastID, RowsAffected: affected}
-		results = append(results, result)
+		err, tx = tx.Commit(), nil
+		return errors.Wrap(err, "could not commit transaction left open at end of input")
 	}
-
-	delta := time.Now().Sub(started).Seconds()
-	return &ExecuteResponse{Results: results, Time: delta}, nil
+	return nil
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulstuart/dqlited/server"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// devCluster describes a cluster of nodes run within one process for development
+type devCluster struct {
+	Nodes    int
+	Dir      string // each node has a subdirectory
+	Host     string // loopback address of the nodes
+	Port     int    // dqlite port of the first node, the rest follow
+	WebPort  int    // web api port of the first node, the rest follow
+	Database string // created at startup
+	Fresh    bool   // remove data from earlier runs
+}
+
+// pidFile records the process running the cluster, so it can be brought down
+func (dc *devCluster) pidFile() string {
+	return filepath.Join(dc.Dir, "cluster.pid")
+}
+
+func (dc *devCluster) address(i int) string {
+	return net.JoinHostPort(dc.Host, strconv.Itoa(dc.Port+i))
+}
+
+func (dc *devCluster) web(i int) string {
+	return net.JoinHostPort(dc.Host, strconv.Itoa(dc.WebPort+i))
+}
+
+// up starts the nodes, the first of which bootstraps the cluster that
+// the rest join, waits for a leader and then runs until signaled to stop
+func (dc *devCluster) up(ctx context.Context) error {
+	if dc.Nodes < 1 {
+		return fmt.Errorf("the cluster needs at least one node")
+	}
+	if b, err := ioutil.ReadFile(dc.pidFile()); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && unix.Kill(pid, 0) == nil {
+			return fmt.Errorf("cluster is already up with pid %d (see: cluster down)", pid)
+		}
+	}
+	if dc.Fresh {
+		for i := 0; i < dc.Nodes; i++ {
+			if err := os.RemoveAll(filepath.Join(dc.Dir, strconv.Itoa(i+1))); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(dc.Dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dc.pidFile(), []byte(fmt.Sprintln(os.Getpid())), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(dc.pidFile())
+
+	// signals are caught before the nodes start, so one during startup
+	// cancels it and the nodes already started are closed
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, unix.SIGINT, unix.SIGTERM, unix.SIGQUIT)
+	defer signal.Stop(sigs)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signaled := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Println("bringing cluster down on signal:", sig)
+			// another signal stops the process without waiting for the nodes
+			signal.Stop(sigs)
+			close(signaled)
+			cancel()
+		case <-done:
+		}
+	}()
+	// interrupted reports a signal as the cause of a startup failure
+	interrupted := func(err error) error {
+		select {
+		case <-signaled:
+			return fmt.Errorf("cluster startup canceled")
+		default:
+			return err
+		}
+	}
+
+	var servers []*server.Server
+	defer func() {
+		// the whole cluster is going, so there's nothing to hand over
+		for i := len(servers) - 1; i >= 0; i-- {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := servers[i].Close(ctx); err != nil {
+				log.Printf("node %d: %v\n", i+1, err)
+			}
+			cancel()
+		}
+	}()
+
+	for i := 0; i < dc.Nodes; i++ {
+		cfg := server.ServerConfig{
+			ID:       i + 1,
+			Dir:      filepath.Join(dc.Dir, strconv.Itoa(i+1)),
+			Address:  dc.address(i),
+			Skip:     i == 0,
+			Web:      dc.web(i),
+			Handlers: webHandlers,
+			Ready:    databaseSetup(dc.Database, ""),
+		}
+		if i > 0 {
+			cfg.Cluster = []string{dc.address(0)}
+		}
+		s, err := server.New(cfg)
+		if err != nil {
+			return err
+		}
+		if err := s.Start(ctx); err != nil {
+			return interrupted(errors.Wrapf(err, "node %d failed to start", i+1))
+		}
+		servers = append(servers, s)
+	}
+
+	leader, err := servers[0].App().Leader(ctx)
+	if err != nil {
+		return interrupted(errors.Wrap(err, "no leader elected"))
+	}
+	info, err := leader.Leader(ctx)
+	leader.Close()
+	if err != nil || info == nil {
+		return interrupted(errors.Wrap(err, "no leader elected"))
+	}
+
+	cluster := make([]string, dc.Nodes)
+	fmt.Printf("cluster is up with %d nodes, leader is node %d\n", dc.Nodes, info.ID)
+	for i := range servers {
+		cluster[i] = dc.address(i)
+		fmt.Printf("  node %d: %s  web: http://%s/\n", i+1, dc.address(i), dc.web(i))
+	}
+	fmt.Printf("export DQLITED_CLUSTER=%s\n", strings.Join(cluster, ","))
+	fmt.Println("press Ctrl-C (or run: cluster down) to stop")
+
+	<-signaled
+	return nil
+}
+
+// down stops the process running the cluster and waits for it to exit
+func (dc *devCluster) down(timeout time.Duration) error {
+	b, err := ioutil.ReadFile(dc.pidFile())
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no cluster is up in %s", dc.Dir)
+	}
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return errors.Wrapf(err, "invalid pid file: %s", dc.pidFile())
+	}
+	if err := unix.Kill(pid, unix.SIGTERM); err != nil {
+		// the process is gone, so its pid file is stale
+		os.Remove(dc.pidFile())
+		return errors.Wrapf(err, "can't stop cluster with pid %d", pid)
+	}
+	deadline := time.Now().Add(timeout)
+	for unix.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster with pid %d is still running after %s", pid, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Println("cluster is down")
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// discover locates the cluster through DNS rather than --cluster, if set.
+// It takes the place of the cluster addresses (see useDiscovery), so
+// a cluster given explicitly is used as is.
+var discover string
+
+// Resolver looks up peers in DNS; net.DefaultResolver is one, and a stub
+// can take its place for testing
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Discovery resolves the addresses of cluster nodes from DNS records, either:
+//
+//	dns:srv:<name>       the targets and ports of SRV records, e.g., dns:srv:_dqlite._tcp.example.com
+//	dns:a:<host>:<port>  the A/AAAA records of the host, at the port, e.g., dns:a:dqlbox:9181
+type Discovery struct {
+	srv      bool
+	name     string
+	port     string
+	resolver Resolver
+}
+
+// ParseDiscovery returns the discovery for the spec, using the resolver for lookups
+func ParseDiscovery(spec string, resolver Resolver) (*Discovery, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] != "dns" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid discovery (want dns:srv:<name> or dns:a:<host>:<port>): %q", spec)
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	d := &Discovery{name: parts[2], resolver: resolver}
+	switch parts[1] {
+	case "srv":
+		d.srv = true
+	case "a":
+		host, port, err := net.SplitHostPort(parts[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid discovery: %q", spec)
+		}
+		d.name, d.port = host, port
+	default:
+		return nil, fmt.Errorf("invalid discovery record type %q (want srv or a): %q", parts[1], spec)
+	}
+	return d, nil
+}
+
+func (d *Discovery) String() string {
+	if d.srv {
+		return "dns:srv:" + d.name
+	}
+	return "dns:a:" + net.JoinHostPort(d.name, d.port)
+}
+
+// Addresses returns the node addresses found, sorted
+func (d *Discovery) Addresses(ctx context.Context) ([]string, error) {
+	var addresses []string
+	if d.srv {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't discover: %s", d)
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			addresses = append(addresses, net.JoinHostPort(host, fmt.Sprint(srv.Port)))
+		}
+	} else {
+		hosts, err := d.resolver.LookupHost(ctx, d.name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't discover: %s", d)
+		}
+		for _, host := range hosts {
+			addresses = append(addresses, net.JoinHostPort(host, d.port))
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no nodes discovered: %s", d)
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// clusterDiscovery returns the discovery if the cluster is given as a
+// discovery spec, or nil if it is given as addresses
+func clusterDiscovery(cluster []string, resolver Resolver) (*Discovery, error) {
+	if len(cluster) != 1 || !strings.HasPrefix(cluster[0], "dns:") {
+		return nil, nil
+	}
+	return ParseDiscovery(cluster[0], resolver)
+}
+
+// discoverCluster returns the addresses of the cluster nodes, found
+// through the resolver if the cluster is given as a discovery spec
+func discoverCluster(ctx context.Context, resolver Resolver, cluster []string) ([]string, error) {
+	d, err := clusterDiscovery(cluster, resolver)
+	if err != nil || d == nil {
+		return cluster, err
+	}
+	return d.Addresses(ctx)
+}
+
+// useDiscovery makes the discovery spec the cluster of the command,
+// unless the cluster addresses were given on the command line
+func useDiscovery(flags *pflag.FlagSet) error {
+	f := flags.Lookup("cluster")
+	if f == nil || f.Changed || discover == "" {
+		return nil
+	}
+	return errors.Wrapf(f.Value.Set(discover), "invalid discovery: %q", discover)
+}
+
+// omitSelf removes the node's own address from the peers, which
+// may name it by another host name or by its IP address
+func omitSelf(ctx context.Context, resolver Resolver, address string, peers []string) []string {
+	self := map[string]bool{address: true}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		if ips, err := resolver.LookupHost(ctx, host); err == nil {
+			for _, ip := range ips {
+				self[net.JoinHostPort(ip, port)] = true
+			}
+		}
+	}
+	var list []string
+	for _, peer := range peers {
+		if !self[peer] {
+			list = append(list, peer)
+		}
+	}
+	return list
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// fakeResolver answers from its records, keyed by name
+type fakeResolver struct {
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	hosts, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return hosts, nil
+}
+
+func TestDiscovery(t *testing.T) {
+	resolver := fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_dqlite._tcp.example.com": {
+				{Target: "db2.example.com.", Port: 9182},
+				{Target: "db1.example.com.", Port: 9181},
+			},
+			"_empty._tcp.example.com": {},
+		},
+		hosts: map[string][]string{
+			"dqlbox": {"10.0.0.2", "10.0.0.1"},
+			"empty":  {},
+		},
+	}
+	tests := []struct {
+		spec string
+		want []string
+		err  string
+	}{
+		{"dns:srv:_dqlite._tcp.example.com", []string{"db1.example.com:9181", "db2.example.com:9182"}, ""},
+		{"dns:a:dqlbox:9181", []string{"10.0.0.1:9181", "10.0.0.2:9181"}, ""},
+		{"dns:srv:_empty._tcp.example.com", nil, "no nodes discovered"},
+		{"dns:a:empty:9181", nil, "no nodes discovered"},
+		{"dns:a:missing:9181", nil, "can't discover"},
+		{"dns:a:dqlbox", nil, "invalid discovery"},
+		{"dns:mx:example.com", nil, "invalid discovery record type"},
+		{"dns:srv", nil, "invalid discovery"},
+	}
+	for _, test := range tests {
+		got, err := discoverCluster(context.Background(), resolver, []string{test.spec})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want %q", test.spec, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestDiscoverClusterAddresses(t *testing.T) {
+	// addresses are used as given, without any lookups
+	cluster := []string{"127.0.0.1:9181", "127.0.0.1:9182"}
+	got, err := discoverCluster(context.Background(), fakeResolver{}, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cluster) {
+		t.Errorf("got %v, want %v", got, cluster)
+	}
+}
+
+func TestUseDiscovery(t *testing.T) {
+	defer func(d string) { discover = d }(discover)
+	discover = "dns:srv:_dqlite._tcp.example.com"
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{discover}},
+		{[]string{"--cluster", "127.0.0.1:9181"}, []string{"127.0.0.1:9181"}},
+	}
+	for _, test := range tests {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		cluster := flags.StringSlice("cluster", []string{"127.0.0.1:9999"}, "")
+		if err := flags.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := useDiscovery(flags); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*cluster, test.want) {
+			t.Errorf("%v: got cluster %v, want %v", test.args, *cluster, test.want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dotHelp describes the sqlite3 cli commands supported by Eval
+const dotHelp = `.databases           list names and files of attached databases
+.dump ?TABLE?        render database content as SQL
+.fullschema          show schema and the content of sqlite_stat tables
+.import FILE TABLE   import data from FILE into TABLE (first row names the columns)
+.indexes ?TABLE?     show names of indexes
+.mode MODE           set output mode (table, column, box, line, csv, tsv, json, ndjson, or markdown)
+.read FILE           read input from FILE
+.schema ?TABLE?      show the CREATE statements
+.show                show the current values for various settings
+.tables              list names of tables
+`
+
+// sqlite formats timestamps as text using this layout
+const sqliteTimestamp = "2006-01-02 15:04:05.999999999-07:00"
+
+// schemaObject is an entry in sqlite_master
+type schemaObject struct {
+	Name, Type, SQL string
+}
+
+// dotCommand emulates the "dot" commands of the sqlite3 cli
+func (dx *DBX) dotCommand(text string) error {
+	args := strings.Fields(text)
+	switch args[0] {
+	case ".tables":
+		return dx.query("select name from sqlite_master where type='table' order by name")
+	case ".schema":
+		return dx.schema(optionalArg(args))
+	case ".fullschema":
+		return dx.fullSchema()
+	case ".indexes", ".indices":
+		query := "select name from sqlite_master where type='index'"
+		if table := optionalArg(args); table != "" {
+			query += " and tbl_name like " + quoteString(table)
+		}
+		return dx.query(query + " order by name")
+	case ".databases":
+		return dx.query("select name, file from pragma_database_list")
+	case ".dump":
+		return dx.dump(optionalArg(args))
+	case ".read":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: .read FILE")
+		}
+		return dx.loadFile(args[1], false)
+	case ".import":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: .import FILE TABLE")
+		}
+		stats, err := dx.importFile(args[1], ImportOptions{Table: args[2], Create: true})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(dx.w, stats)
+		return nil
+	case ".mode":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: .mode MODE")
+		}
+		return dx.setMode(args[1])
+	case ".show":
+		return dx.show()
+	case ".help":
+		fmt.Fprint(dx.w, dotHelp)
+		return nil
+	}
+	return fmt.Errorf("unknown command: %q -- use .help for a list", args[0])
+}
+
+// optionalArg returns the first argument of a dot-command, if given
+func optionalArg(args []string) string {
+	if len(args) > 1 {
+		return args[1]
+	}
+	return ""
+}
+
+// setMode changes how query results are displayed
+func (dx *DBX) setMode(mode string) error {
+	if _, ok := renderers[mode]; !ok {
+		return fmt.Errorf("unsupported mode: %q -- must be %s", mode, choiceList(renderFormats()...))
+	}
+	dx.format = mode
+	return nil
+}
+
+// mode returns the name of the current display mode
+func (dx *DBX) mode() string {
+	if dx.format == "" {
+		return defaultFormat
+	}
+	return dx.format
+}
+
+// show prints the current settings
+func (dx *DBX) show() error {
+	fmt.Fprintf(dx.w, "%10s: %s\n", "database", dx.name)
+	fmt.Fprintf(dx.w, "%10s: %s\n", "headers", onOffString(dx.header))
+	fmt.Fprintf(dx.w, "%10s: %s\n", "mode", dx.mode())
+	fmt.Fprintf(dx.w, "%10s: %s\n", "verbose", onOffString(dx.verbose))
+	return nil
+}
+
+func onOffString(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// schema prints the CREATE statements for all objects, or those of the given table
+func (dx *DBX) schema(table string) error {
+	query := "select sql || ';' from sqlite_master where sql is not null"
+	if table != "" {
+		query += " and tbl_name like " + quoteString(table)
+	}
+	return dx.query(query + " order by tbl_name, type desc, name")
+}
+
+// fullSchema prints the schema along with the statistics sqlite uses for query planning
+func (dx *DBX) fullSchema() error {
+	objects, err := dx.objects("select name, type, sql from sqlite_master where sql is not null order by tbl_name, type desc, name")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		fmt.Fprintf(dx.w, "%s;\n", obj.SQL)
+	}
+	stats, err := dx.objects("select name, type, '' from sqlite_master where name like 'sqlite_stat%' order by name")
+	if err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		fmt.Fprintln(dx.w, "/* No STAT tables available */")
+		return nil
+	}
+	fmt.Fprintln(dx.w, "ANALYZE sqlite_master;")
+	for _, stat := range stats {
+		if err := dx.dumpRows(stat.Name); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(dx.w, "ANALYZE sqlite_master;")
+	return nil
+}
+
+// objects returns the sqlite_master entries selected by the query,
+// which must return the name, type, and sql columns
+func (dx *DBX) objects(query string) ([]schemaObject, error) {
+	rows, err := dx.db.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "schema query failed")
+	}
+	defer rows.Close()
+
+	var objects []schemaObject
+	for rows.Next() {
+		var obj schemaObject
+		if err := rows.Scan(&obj.Name, &obj.Type, &obj.SQL); err != nil {
+			return nil, errors.Wrap(err, "failed to scan schema")
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// dump renders the database (or just the given table) as sql statements
+// that will recreate it, in the same form as the sqlite3 cli
+func (dx *DBX) dump(table string) error {
+	filter := ""
+	if table != "" {
+		filter = " and tbl_name like " + quoteString(table)
+	}
+	// objects are collected up front as the connection is not shared
+	tables, err := dx.objects("select name, type, sql from sqlite_master where sql is not null and type = 'table'" + filter + " order by rowid")
+	if err != nil {
+		return err
+	}
+	others, err := dx.objects("select name, type, sql from sqlite_master where sql is not null and type in ('index', 'trigger', 'view')" + filter + " order by rowid")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(dx.w, "PRAGMA foreign_keys=OFF;")
+	fmt.Fprintln(dx.w, "BEGIN TRANSACTION;")
+	sequence := false
+	for _, obj := range tables {
+		switch {
+		case obj.Name == "sqlite_sequence":
+			sequence = true
+			continue
+		case strings.HasPrefix(obj.Name, "sqlite_stat"):
+			fmt.Fprintln(dx.w, "ANALYZE sqlite_master;")
+		case strings.HasPrefix(obj.Name, "sqlite_"):
+			continue
+		default:
+			fmt.Fprintf(dx.w, "%s;\n", obj.SQL)
+		}
+		if err := dx.dumpRows(obj.Name); err != nil {
+			return err
+		}
+	}
+	if sequence {
+		fmt.Fprintln(dx.w, "DELETE FROM sqlite_sequence;")
+		if err := dx.dumpRows("sqlite_sequence"); err != nil {
+			return err
+		}
+	}
+	for _, obj := range others {
+		fmt.Fprintf(dx.w, "%s;\n", obj.SQL)
+	}
+	fmt.Fprintln(dx.w, "COMMIT;")
+	return nil
+}
+
+// dumpRows writes the contents of the table as INSERT statements
+func (dx *DBX) dumpRows(table string) error {
+	rows, err := dx.db.Query("select * from " + quoteIdent(table))
+	if err != nil {
+		return errors.Wrapf(err, "dump query failed for table: %s", table)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]interface{}, len(columns))
+	scanTo := make([]interface{}, len(columns))
+	for i := range buffer {
+		scanTo[i] = &buffer[i]
+	}
+	prefix := "INSERT INTO " + quoteIdent(table) + " VALUES("
+	for rows.Next() {
+		if err := rows.Scan(scanTo...); err != nil {
+			return errors.Wrap(err, "failed to scan row")
+		}
+		values := make([]string, len(buffer))
+		for i, value := range buffer {
+			values[i] = sqlLiteral(value)
+		}
+		fmt.Fprintf(dx.w, "%s%s);\n", prefix, strings.Join(values, ","))
+	}
+	return rows.Err()
+}
+
+// quoteString returns s as an sql string literal
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// quoteIdent returns s as a quoted sql identifier
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// sqlLiteral renders a scanned value as an sql literal
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "1e999"
+		case math.IsInf(v, -1):
+			return "-1e999"
+		case math.IsNaN(v):
+			return "NULL"
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			// keep it from being read back as an integer
+			s += ".0"
+		}
+		return s
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case string:
+		return quoteString(v)
+	case time.Time:
+		return quoteString(v.Format(sqliteTimestamp))
+	}
+	return quoteString(fmt.Sprint(value))
+}
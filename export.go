@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// export writes the results of the query to the named file (or stdout if
+// not given) in the given format, streaming rows as they are scanned
+func (dx *DBX) export(query, format, fileName string) error {
+	if fileName == "" || fileName == "-" {
+		return dx.exportTo(dx.w, query, format)
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return errors.Wrapf(err, "can't create export file: %s", fileName)
+	}
+	if err := dx.exportTo(f, query, format); err != nil {
+		f.Close()
+		return err
+	}
+	// a full disk may only be reported when the file is closed
+	return errors.Wrapf(f.Close(), "can't close export file: %s", fileName)
+}
+
+// exportTo writes the results of the query to w in the given format
+func (dx *DBX) exportTo(w io.Writer, query, format string) error {
+	// renderers buffer their output, so a failed write surfaces on flush
+	bw := bufio.NewWriter(w)
+	qdb, err := NewRenderer(format, bw, RenderOptions{Header: dx.header})
+	if err != nil {
+		return err
+	}
+	if err := dx.queryer(qdb, query); err != nil {
+		return errors.Wrapf(err, "export query failed: %q", query)
+	}
+	return errors.Wrap(bw.Flush(), "export write failed")
+}
@@ -0,0 +1,14 @@
+module github.com/paulstuart/dqlited
+
+go 1.14
+
+require (
+	github.com/canonical/go-dqlite v1.11.1
+	github.com/chzyer/readline v1.5.1
+	github.com/klauspost/compress v1.13.6
+	github.com/pkg/errors v0.9.1
+	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -50,7 +50,7 @@ func hammer(kp *KeyPair, id, count int, logger LogFunc, dbName string, cluster .
 		log.Fatalln(err)
 	}
 	log.Println("hammer connected")
-	defer dx.db.Close()
+	defer dx.Close()
 	if err := hammerPrep(dx.db); err != nil {
 		log.Fatalln(err)
 	}
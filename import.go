@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultImportChunk is the number of rows committed per transaction
+	defaultImportChunk = 1000
+
+	// importSample is the number of rows examined to infer column types
+	importSample = 1000
+)
+
+// ImportOptions control how a delimited text file is loaded into a table
+type ImportOptions struct {
+	Table   string // table to load
+	Comma   rune   // field delimiter, inferred from the file name if unset
+	Create  bool   // create the table if it does not exist
+	Infer   bool   // infer column types when creating the table
+	Chunk   int    // rows per transaction
+	Rejects string // file to record rows that fail to insert
+}
+
+// ImportStats summarize the outcome of an import
+type ImportStats struct {
+	Rows     int
+	Imported int
+	Rejected int
+}
+
+func (s ImportStats) String() string {
+	return fmt.Sprintf("rows: %d imported: %d rejected: %d", s.Rows, s.Imported, s.Rejected)
+}
+
+// importFile loads a CSV (or TSV) file into a table, with the
+// header row naming the columns to populate
+func (dx *DBX) importFile(fileName string, opts ImportOptions) (*ImportStats, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if opts.Comma == 0 {
+		opts.Comma = ','
+		if strings.HasSuffix(strings.ToLower(fileName), ".tsv") {
+			opts.Comma = '\t'
+		}
+	}
+	stats, err := dx.importReader(f, opts)
+	return stats, errors.Wrapf(err, "import failed for file: %s", fileName)
+}
+
+// importReader loads delimited text into a table in chunks,
+// with each chunk applied as a single transaction via transact
+func (dx *DBX) importReader(r io.Reader, opts ImportOptions) (*ImportStats, error) {
+	if opts.Table == "" {
+		return nil, fmt.Errorf("no table specified")
+	}
+	if opts.Chunk < 1 {
+		opts.Chunk = defaultImportChunk
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = opts.Comma
+	cr.FieldsPerRecord = -1 // mismatches are rejected rather than fatal
+	header, err := cr.Read()
+	if err == io.EOF {
+		return &ImportStats{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read header")
+	}
+
+	// rows read to determine column types still need to be imported
+	var pending [][]string
+	var types []string
+	exists, err := dx.tableExists(opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if !opts.Create {
+			return nil, fmt.Errorf("table %s does not exist", opts.Table)
+		}
+		if opts.Infer {
+			for len(pending) < importSample {
+				record, err := cr.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, errors.Wrap(err, "can't read sample rows")
+				}
+				pending = append(pending, record)
+			}
+		}
+		types = inferTypes(header, pending)
+		if err := dx.createTable(opts.Table, header, types); err != nil {
+			return nil, err
+		}
+	}
+
+	var rejects *csv.Writer
+	if opts.Rejects != "" {
+		f, err := os.Create(opts.Rejects)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't create reject file: %s", opts.Rejects)
+		}
+		defer f.Close()
+		rejects = csv.NewWriter(f)
+		rejects.Comma = opts.Comma
+		rejects.Write(append(header, "error"))
+		defer rejects.Flush()
+	}
+
+	columns := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = quoteIdent(column)
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES(", quoteIdent(opts.Table), strings.Join(columns, ","))
+
+	stats := &ImportStats{}
+	reject := func(record []string, err error) error {
+		stats.Rejected++
+		if rejects == nil {
+			return errors.Wrapf(err, "row %d rejected", stats.Rows)
+		}
+		return rejects.Write(append(record, err.Error()))
+	}
+
+	var records [][]string
+	var statements []string
+	flush := func() error {
+		defer func() {
+			records = records[:0]
+			statements = statements[:0]
+		}()
+		if len(statements) == 0 {
+			return nil
+		}
+		err := transactList(dx.db, dx.verbose, statements)
+		if err == nil {
+			stats.Imported += len(statements)
+			return nil
+		}
+		if rejects == nil || !isSqliteError(err) {
+			return err
+		}
+		// find the offending rows by replaying the chunk one row at a time
+		for i, statement := range statements {
+			if _, err := dx.exec(statement); err != nil {
+				if !isSqliteError(err) {
+					return err
+				}
+				if err := reject(records[i], err); err != nil {
+					return err
+				}
+				continue
+			}
+			stats.Imported++
+		}
+		return nil
+	}
+
+	for {
+		var record []string
+		if len(pending) > 0 {
+			record, pending = pending[0], pending[1:]
+		} else {
+			record, err = cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return stats, errors.Wrapf(err, "can't read row %d", stats.Rows+1)
+			}
+		}
+		stats.Rows++
+		if len(record) != len(header) {
+			err := fmt.Errorf("expected %d fields, found %d", len(header), len(record))
+			if err := reject(record, err); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		values := make([]string, len(record))
+		for i, value := range record {
+			values[i] = csvLiteral(value, types, i)
+		}
+		records = append(records, record)
+		statements = append(statements, prefix+strings.Join(values, ",")+")")
+		if len(statements) < opts.Chunk {
+			continue
+		}
+		if err := flush(); err != nil {
+			return stats, err
+		}
+		if dx.verbose {
+			log.Println("imported:", stats.Imported)
+		}
+	}
+	return stats, flush()
+}
+
+// tableExists reports whether the table is in the schema
+func (dx *DBX) tableExists(table string) (bool, error) {
+	count, err := queryColumn(dx.db, "select count(*) from sqlite_master where type='table' and name=?", table)
+	if err != nil {
+		return false, errors.Wrapf(err, "can't check for table: %s", table)
+	}
+	return count != "0", nil
+}
+
+// createTable creates a table with the given columns and their types
+func (dx *DBX) createTable(table string, columns, types []string) error {
+	defs := make([]string, len(columns))
+	for i, column := range columns {
+		defs[i] = quoteIdent(column) + " " + types[i]
+	}
+	create := fmt.Sprintf("CREATE TABLE %s(%s)", quoteIdent(table), strings.Join(defs, ", "))
+	if dx.verbose {
+		log.Println(create)
+	}
+	_, err := dx.exec(create)
+	return errors.Wrapf(err, "can't create table: %s", table)
+}
+
+// inferTypes returns the narrowest sqlite type that can hold
+// all the sample values of each column
+func inferTypes(header []string, sample [][]string) []string {
+	types := make([]string, len(header))
+	for i := range header {
+		seen := false
+		isInt, isReal := true, true
+		for _, record := range sample {
+			if i >= len(record) {
+				continue
+			}
+			value := strings.TrimSpace(record[i])
+			if value == "" {
+				continue
+			}
+			seen = true
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				isInt = false
+			}
+			if !isNumeric(value) {
+				isReal = false
+			}
+		}
+		switch {
+		case !seen:
+			types[i] = "TEXT"
+		case isInt:
+			types[i] = "INTEGER"
+		case isReal:
+			types[i] = "REAL"
+		default:
+			types[i] = "TEXT"
+		}
+	}
+	return types
+}
+
+// csvLiteral renders a field as an sql literal for its column type,
+// where empty numeric fields are taken to be NULL
+func csvLiteral(value string, types []string, i int) string {
+	if types == nil || types[i] == "TEXT" {
+		return quoteString(value)
+	}
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return "NULL"
+	}
+	if isNumeric(trimmed) {
+		return trimmed
+	}
+	return quoteString(value)
+}
+
+// isNumeric reports whether s is a decimal number that sqlite will accept as
+// a literal (ParseFloat also allows "Inf", "NaN" and hexadecimal forms)
+func isNumeric(s string) bool {
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return false
+	}
+	return !strings.ContainsAny(strings.ToLower(s), "abcdfghijklmnopqrstuvwxyz")
+}
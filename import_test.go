@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInferTypes(t *testing.T) {
+	header := []string{"id", "price", "name", "empty", "mixed", "short"}
+	sample := [][]string{
+		{"1", "1.50", "apple", "", "10", "x"},
+		{"2", "2", "banana", " ", "ten"},
+		{" 3 ", "-0.25", "42", "", "1e3"},
+	}
+	want := []string{"INTEGER", "REAL", "TEXT", "TEXT", "TEXT", "TEXT"}
+	if got := inferTypes(header, sample); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestInferTypesNotNumbers(t *testing.T) {
+	// ParseFloat accepts these, but they aren't numbers in a csv file
+	for _, value := range []string{"inf", "NaN", "0x1p-2"} {
+		got := inferTypes([]string{"a"}, [][]string{{value}})
+		if got[0] != "TEXT" {
+			t.Errorf("%q: got %s, want TEXT", value, got[0])
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/paulstuart/dqlited/script"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultLoadChunk is the number of statements committed per transaction
+	// when a checkpoint is requested without specifying a chunk size
+	defaultLoadChunk = 1000
+
+	// defaultProgress is the interval between progress reports
+	defaultProgress = 10 * time.Second
+
+	// chunkRetries is how many times a chunk is attempted when the
+	// failure is not due to the statements themselves (e.g., leader change)
+	chunkRetries = 5
+
+	// stdinName is the file name that reads standard input
+	stdinName = "-"
+)
+
+// LoadOptions control how a sql script is applied
+type LoadOptions struct {
+	Batch      bool          // apply all statements as a single transaction
+	Chunk      int           // statements committed per transaction
+	Checkpoint string        // file recording progress, so an interrupted load can resume
+	Progress   time.Duration // interval between progress reports (0 for none)
+	Rejects    string        // file to record failing statements, to continue past them
+}
+
+// LoadStats summarize the outcome of a load
+type LoadStats struct {
+	Files      int           // files applied
+	Statements int           // statements applied
+	Skipped    int           // statements already applied by an earlier run
+	Rejected   int           // statements that failed and were set aside
+	Chunks     int           // transactions committed
+	Elapsed    time.Duration // time spent applying statements
+}
+
+// Rate returns the statements applied per second
+func (s LoadStats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Statements) / s.Elapsed.Seconds()
+}
+
+func (s LoadStats) String() string {
+	return fmt.Sprintf("files: %d statements: %d skipped: %d rejected: %d chunks: %d elapsed: %s rate: %.1f/sec",
+		s.Files, s.Statements, s.Skipped, s.Rejected, s.Chunks, s.Elapsed.Round(time.Millisecond), s.Rate())
+}
+
+// Checkpoint records how far a load has progressed
+type Checkpoint struct {
+	Done       []string  `json:"done,omitempty"` // files completely applied
+	File       string    `json:"file,omitempty"` // file being applied
+	Statements int       `json:"statements"`     // statements of File committed
+	Updated    time.Time `json:"updated"`
+}
+
+// readCheckpoint returns the checkpoint saved in the file, if there is one
+func readCheckpoint(fileName string) (*Checkpoint, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, errors.Wrapf(err, "invalid checkpoint file: %s", fileName)
+	}
+	return &cp, nil
+}
+
+// save writes the checkpoint to a temporary file that replaces the
+// original, so an interruption can't leave a partial checkpoint behind
+func (cp *Checkpoint) save(fileName string) error {
+	if fileName == "" {
+		return nil
+	}
+	cp.Updated = time.Now()
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return errors.Wrapf(err, "can't write checkpoint file: %s", tmp)
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// Rejects records statements that sqlite refused, along with the errors,
+// so that a load can continue past them. The file is itself sql, with
+// the source and error of each statement noted in a comment before it.
+type Rejects struct {
+	Source string // input being loaded
+	Count  int    // statements recorded
+	f      *os.File
+	w      *bufio.Writer
+}
+
+// NewRejects creates the reject file
+func NewRejects(fileName string) (*Rejects, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't create reject file: %s", fileName)
+	}
+	return &Rejects{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Add records the failed statement, which started on the given line of the input
+func (r *Rejects) Add(line int, statement string, err error) error {
+	r.Count++
+	msg := strings.Replace(err.Error(), "\n", " ", -1)
+	fmt.Fprintf(r.w, "-- %s:%d: %s\n%s;\n", r.Source, line, msg, statement)
+	return r.w.Flush()
+}
+
+// Close flushes and closes the reject file
+func (r *Rejects) Close() error {
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// openInput opens the named file for reading, with "-" being standard input.
+// Files ending in .gz or .zst are decompressed as they are read.
+func openInput(fileName string) (io.ReadCloser, error) {
+	if fileName == stdinName {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(fileName, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "invalid gzip file: %s", fileName)
+		}
+		return &decompressor{Reader: gz, closers: []io.Closer{gz, f}}, nil
+	case strings.HasSuffix(fileName, ".zst"), strings.HasSuffix(fileName, ".zstd"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "invalid zstd file: %s", fileName)
+		}
+		return &decompressor{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), f}}, nil
+	}
+	return f, nil
+}
+
+// decompressor closes the decompressing reader along with the file beneath it
+type decompressor struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decompressor) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// loadChunks applies the statements in the files, in order, committing every
+// opts.Chunk statements. When a checkpoint file is given, progress is recorded
+// after each commit and statements committed by an earlier run are skipped.
+func (dx *DBX) loadChunks(fileNames []string, opts LoadOptions) (*LoadStats, error) {
+	if opts.Chunk < 1 {
+		opts.Chunk = defaultLoadChunk
+	}
+	cp := &Checkpoint{}
+	if opts.Checkpoint != "" {
+		saved, err := readCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil {
+			for _, name := range append(saved.Done, saved.File) {
+				if name != "" && !contains(fileNames, name) {
+					return nil, fmt.Errorf("checkpoint file %s is for a different load (%s)", opts.Checkpoint, name)
+				}
+			}
+			cp = saved
+		}
+	}
+
+	stats := &LoadStats{}
+	started := time.Now()
+	for _, fileName := range fileNames {
+		if contains(cp.Done, fileName) {
+			log.Println("already loaded:", fileName)
+			continue
+		}
+		if cp.File != fileName {
+			cp.File, cp.Statements = fileName, 0
+		} else if cp.Statements > 0 {
+			log.Printf("resuming %s after %d statements\n", fileName, cp.Statements)
+		}
+		if dx.rejects != nil {
+			dx.rejects.Source = fileName
+		}
+		r, err := openInput(fileName)
+		if err != nil {
+			return stats, err
+		}
+		err = dx.loadReader(r, opts, cp, stats, started)
+		r.Close()
+		if err != nil {
+			return stats, errors.Wrapf(err, "load failed for file: %s", fileName)
+		}
+		stats.Files++
+		cp.Done = append(cp.Done, fileName)
+		cp.File, cp.Statements = "", 0
+		if err := cp.save(opts.Checkpoint); err != nil {
+			return stats, err
+		}
+	}
+	stats.Elapsed = time.Since(started)
+	if opts.Checkpoint != "" {
+		// the load is complete, so there is nothing to resume
+		if err := os.Remove(opts.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// loadReader applies the statements read from r in chunks, skipping
+// those the checkpoint records as committed and advancing it as it goes
+func (dx *DBX) loadReader(r io.Reader, opts LoadOptions, cp *Checkpoint, stats *LoadStats, started time.Time) error {
+	skip := cp.Statements
+	reported := time.Now()
+	var chunk []string
+	var lines []int // where each statement of the chunk started
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		applied := len(chunk)
+		if err := dx.commitChunk(chunk); err != nil {
+			if dx.rejects == nil || !isSqliteError(err) {
+				return errors.Wrapf(err, "chunk starting after statement %d", cp.Statements)
+			}
+			// find the offending statements by replaying the chunk one at a time
+			for i, stmt := range chunk {
+				if _, err := dx.exec(stmt); err != nil {
+					if !isSqliteError(err) {
+						return errors.Wrapf(err, "line %d", lines[i])
+					}
+					if err := dx.rejects.Add(lines[i], stmt, err); err != nil {
+						return err
+					}
+					applied--
+					stats.Rejected++
+				}
+			}
+		}
+		stats.Statements += applied
+		stats.Chunks++
+		cp.Statements += len(chunk)
+		chunk = chunk[:0]
+		lines = lines[:0]
+		if err := cp.save(opts.Checkpoint); err != nil {
+			return err
+		}
+		if opts.Progress > 0 && time.Since(reported) >= opts.Progress {
+			reported = time.Now()
+			stats.Elapsed = reported.Sub(started)
+			log.Printf("%s: %d statements applied (%.1f/sec)\n", cp.File, cp.Statements, stats.Rate())
+		}
+		return nil
+	}
+
+	scanner := script.NewStatementScanner(r)
+	for scanner.Scan() {
+		stmt := scanner.Text()
+		if script.TxControl(stmt) != "" {
+			// chunks are the transactions
+			continue
+		}
+		if skip > 0 {
+			skip--
+			stats.Skipped++
+			continue
+		}
+		chunk = append(chunk, stmt)
+		lines = append(lines, scanner.Line())
+		if len(chunk) < opts.Chunk {
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// commitChunk applies the statements as a single transaction, retrying
+// when the failure is not from the statements themselves, e.g., the
+// leader changed and the connection must find the new one. A failed
+// commit is not retried, as the chunk may have been applied regardless.
+func (dx *DBX) commitChunk(chunk []string) error {
+	delay := 100 * time.Millisecond
+	var err error
+	for i := 0; i < chunkRetries; i++ {
+		if err = transactList(dx.db, false, chunk); err == nil || isSqliteError(err) {
+			return err
+		}
+		if _, ok := err.(commitError); ok {
+			return errors.Wrap(err, "the chunk may have been applied, check before resuming")
+		}
+		log.Printf("chunk failed (%d/%d): %v\n", i+1, chunkRetries, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
+
+// contains reports whether the list includes s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
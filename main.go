@@ -5,13 +5,16 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/canonical/go-dqlite/client"
+	"github.com/paulstuart/dqlited/server"
 	"github.com/paulstuart/envy"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -36,13 +39,52 @@ func main() {
 	root := newRoot(cmd)
 	if err := root.Execute(); err != nil {
 		log.Println(err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
+// exit codes, so scripts can tell why a command failed
+const (
+	exitFailure  = 1 // any error not listed below
+	exitUsage    = 2 // invalid arguments
+	exitConnect  = 3 // can't connect to the database
+	exitSQL      = 4 // a statement was rejected by sqlite
+	exitRejected = 5 // statements were set aside by --continue-on-error
+)
+
+// exitError is an error that determines the exit code of the process
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// withExit associates the exit code with the error
+func withExit(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for the error returned by a command
+func exitCode(err error) int {
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	if isSqliteError(err) {
+		return exitSQL
+	}
+	return exitFailure
+}
+
 // Return a new root command.
 func newRoot(cmdName string) *cobra.Command {
 	var level, logfile string
+	var configFile, contextName string
 	opts := map[string]int{
 		"debug": int(client.LogDebug),
 		"info":  int(client.LogInfo),
@@ -72,27 +114,46 @@ func newRoot(cmdName string) *cobra.Command {
 				}
 				log.SetOutput(f)
 			}
-			return nil
+			if usesContext(cmd) {
+				if err := applyConfig(configFile, contextName, cmd.Flags()); err != nil {
+					return err
+				}
+			}
+			return useDiscovery(cmd.Flags())
 		},
 		TraverseChildren: true,
 	}
 	cmd.AddCommand(newStatus())
 	cmd.AddCommand(newAdhoc())
+	cmd.AddCommand(newShell())
 	cmd.AddCommand(newAddnode())
 	cmd.AddCommand(newServer())
+	cmd.AddCommand(newDevCluster())
 	cmd.AddCommand(newDumper())
 	cmd.AddCommand(newLoad())
+	cmd.AddCommand(newImport())
+	cmd.AddCommand(newExport())
+	cmd.AddCommand(newConvert())
+	cmd.AddCommand(newMigrate())
+	cmd.AddCommand(newConfig(&configFile))
 	cmd.AddCommand(newVersion())
 	cmd.AddCommand(newHammer())
 	cmd.AddCommand(newReport())
 	cmd.AddCommand(newTransfer())
 	cmd.AddCommand(newAssign())
 	cmd.AddCommand(newRemove())
+	cmd.AddCommand(newDrain(true))
+	cmd.AddCommand(newDrain(false))
 	cmd.AddCommand(newLeaderID())
 
 	flags := cmd.Flags()
 	flags.StringVarP(&level, "level", "z", "error", "log level (debug, info, warn, error)")
 	flags.StringVarP(&logfile, "out", "o", "", "log to file (default is stderr")
+	persistent := cmd.PersistentFlags()
+	persistent.StringVar(&configFile, "config", envy.StringDefault("DQLITED_CONFIG", defaultConfigFile()), "config file of cluster contexts")
+	persistent.StringVar(&contextName, "context", envy.String("DQLITED_CONTEXT"), "context of the config file to use (default is its current-context)")
+	persistent.StringVar(&discover, "discover", envy.String("DQLITED_DISCOVER"), "find cluster nodes in DNS, unless --cluster is given: dns:srv:<name> or dns:a:<host>:<port>")
+	flags.StringVar(&nodeCache, "node-cache", envy.StringDefault("DQLITED_NODE_CACHE", ""), "remember cluster nodes in ~/.dqlited/<name>-<cluster id>.yaml (or a path to a yaml file)")
 	return cmd
 }
 
@@ -111,36 +172,66 @@ func newServer() *cobra.Command {
 	var address string
 	var dbName string
 	var role string
+	var schema string
 	var id, port int
-	var skip bool
-	var timeout time.Duration
+	var voters, standbys int
+	var skip, leave bool
+	var timeout, drain time.Duration
 
 	cmd := &cobra.Command{
-		Use:   "server",
-		Short: "Start a server with web api.",
+		Use:         "server",
+		Short:       "Start a server with web api.",
+		Annotations: map[string]string{noContext: ""},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := server.ServerConfig{
+				ID:      id,
+				Dir:     dir,
+				Address: address,
+				Skip:    skip,
+				Role:    role,
+				Web:     fmt.Sprintf("0.0.0.0:%d", port),
+				Cert:    globalKeys.Cert,
+				Key:     globalKeys.Key,
+				LogFunc: NewLogLog(client.LogDebug),
+				Ready:   databaseSetup(dbName, schema),
+
+				DrainTimeout:    drain,
+				LeaveOnShutdown: leave,
+				Voters:          voters,
+				StandBys:        standbys,
+			}
+			if err := cfg.Validate(); err != nil {
+				return withExit(exitUsage, err)
+			}
+			cmd.SilenceUsage = true
 			ctx, cancel := context.WithTimeout(context.Background(), timeout)
 			defer cancel()
-			//func StartServer(ctx context.Context, id int, dir, address, web string, cluster []string) error {
-			//err := StartServer(ctx, id, port, skip, dbName, dir, address, role, cluster)
-			cluster = omit(address, cluster)
-			kp := &globalKeys
-			err := StartServer(ctx, id, port, kp, dir, address, cluster)
+			peers, err := discoverCluster(ctx, net.DefaultResolver, cluster)
+			if err != nil {
+				return err
+			}
+			cfg.Cluster = omitSelf(ctx, net.DefaultResolver, address, omit(address, peers))
+			err = StartServer(ctx, cfg)
 			log.Println("server is done serving:", err)
-			return nil
+			return err
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.StringVarP(&address, "address", "a", envy.StringDefault("DQLITED_ADDRESS", "127.0.0.1:9181"), "address of the node (default is 127.0.0.1:918<ID>)")
 	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
-	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
+	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to create at startup")
+	flags.StringVar(&schema, "schema", envy.String("DQLITED_SCHEMA"), "sql file to apply to the database at startup (statements should be idempotent)")
 	flags.StringVarP(&dir, "dir", "l", envy.StringDefault("DQLITED_TMP", "/tmp/dqlited"), "database working directory")
-	flags.StringVarP(&role, "role", "r", envy.StringDefault("DQLITED_ROLE", "voter"), "node role, must be one of: 'voter', 'standby', or 'spare'")
+	flags.StringVarP(&role, "role", "r", envy.String("DQLITED_ROLE"), "node role, must be one of: 'voter', 'standby', or 'spare' (default is chosen by the cluster)")
 	flags.IntVarP(&id, "id", "i", envy.IntDefault("DQLITED_ID", 1), "server id")
 	flags.IntVarP(&port, "port", "p", envy.IntDefault("DQLITED_PORT", 4001), "port to serve traffic on")
 	flags.BoolVarP(&skip, "skip", "s", envy.Bool("DQLITED_SKIP"), "do NOT add server to cluster")
+	flags.IntVar(&voters, "voters", envy.IntDefault("DQLITED_VOTERS", 0), "number of voters to keep online, promoting and demoting nodes as needed (0 for no balancing)")
+	flags.IntVar(&standbys, "standbys", envy.IntDefault("DQLITED_STANDBYS", 0), "number of standbys to keep online, along with --voters")
+	flags.BoolVar(&leave, "leave-on-shutdown", envy.Bool("DQLITED_LEAVE"), "remove the node from the cluster when it shuts down")
 	flags.DurationVarP(&timeout, "timeout", "t", time.Minute*5, "time to wait for connection to complete")
+	flags.DurationVar(&drain, "drain-timeout", server.DefaultDrainTimeout, "time to wait for writes in progress at shutdown")
 
 	return cmd
 }
@@ -220,6 +311,103 @@ func newTransfer() *cobra.Command {
 	return cmd
 }
 
+// run a cluster of local nodes for development
+func newDevCluster() *cobra.Command {
+	var dc devCluster
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:         "cluster",
+		Short:       "Run a cluster of nodes on this host for development.",
+		Annotations: map[string]string{noContext: ""},
+	}
+
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Start the nodes and run until Ctrl-C (or cluster down).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return dc.up(ctx)
+		},
+	}
+	flags := up.Flags()
+	flags.IntVarP(&dc.Nodes, "nodes", "n", 3, "number of nodes")
+	flags.StringVar(&dc.Host, "host", "127.0.0.1", "loopback address of the nodes")
+	flags.IntVar(&dc.Port, "port", 9181, "dqlite port of the first node, the rest follow")
+	flags.IntVar(&dc.WebPort, "web-port", 4001, "web api port of the first node, the rest follow")
+	flags.StringVarP(&dc.Database, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to create")
+	flags.BoolVar(&dc.Fresh, "fresh", false, "remove the data of earlier runs")
+	flags.DurationVarP(&timeout, "timeout", "t", time.Minute, "time to wait for the nodes to start")
+
+	down := &cobra.Command{
+		Use:   "down",
+		Short: "Stop the nodes started by cluster up.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return dc.down(timeout)
+		},
+	}
+	down.Flags().DurationVarP(&timeout, "timeout", "t", time.Minute, "time to wait for the nodes to stop")
+
+	cmd.AddCommand(up, down)
+	cmd.PersistentFlags().StringVarP(&dc.Dir, "dir", "l", filepath.Join(os.TempDir(), "dqlited", "cluster"), "directory of the node data")
+	return cmd
+}
+
+// Return a new drain (or undrain) command.
+func newDrain(drain bool) *cobra.Command {
+	var cluster []string
+	var web string
+	var port int
+	var demote bool
+	var timeout time.Duration
+
+	use, short := "drain <id>", "put a node into maintenance, moving leadership and writes elsewhere."
+	if !drain {
+		use, short = "undrain <id>", "take a node out of maintenance."
+	}
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return withExit(exitUsage, err)
+			}
+			cmd.SilenceUsage = true
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if web == "" {
+				if web, err = nodeWeb(ctx, &globalKeys, id, port, cluster); err != nil {
+					return withExit(exitConnect, err)
+				}
+			}
+			m, err := Maintain(ctx, web, drain, demote)
+			if err != nil {
+				return err
+			}
+			if m.Drained {
+				fmt.Printf("node %d drained since %s (demoted: %t)\n", id, m.Since.Format(time.RFC3339), m.Demoted)
+			} else {
+				fmt.Printf("node %d is not drained\n", id)
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
+	flags.StringVarP(&web, "web", "w", "", "web api address of the node (default is its host at --port)")
+	flags.IntVarP(&port, "port", "p", envy.IntDefault("DQLITED_PORT", 4001), "web api port of the node")
+	flags.DurationVarP(&timeout, "timeout", "t", time.Second*60, "time to wait for the node to drain")
+	if drain {
+		flags.BoolVar(&demote, "demote", false, "step down from voter to standby until undrained")
+	}
+	return cmd
+}
+
 // Return a new remove command.
 func newRemove() *cobra.Command {
 	var cluster []string
@@ -341,6 +529,8 @@ func newAssign() *cobra.Command {
 func newAdhoc() *cobra.Command {
 	var cluster []string
 	var dbName string
+	var format string
+	var positional, named []string
 	var divs, headless bool
 
 	cmd := &cobra.Command{
@@ -348,18 +538,16 @@ func newAdhoc() *cobra.Command {
 		Short: "execute a statement against the database.",
 		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			binds, err := bindArgs(positional, named)
+			if err != nil {
+				return err
+			}
 			ctx := context.Background()
 			logger := NewLogFunc(defaultLogLevel, "adhoc:: ", NewLoggingWriter())
-			err := dbCmd(ctx, &globalKeys, dbName, cluster, logger, !headless, divs, strings.Join(args, " "))
+			err = dbCmd(ctx, &globalKeys, dbName, cluster, logger, format, !headless, divs, strings.Join(args, " "), binds...)
 			if err != nil {
-				cause := errors.Cause(err)
-				if sqlErr, ok := cause.(SqliteError); ok {
-					code, msg := sqlErr.SqliteError()
-					fmt.Printf("SqliteError (%d): %s\n", code, msg)
-				} else {
-					fmt.Printf("(%T): %v\n", cause, err)
-				}
-				os.Exit(1)
+				fmt.Println(describeError(err))
+				os.Exit(exitCode(err))
 			}
 			return nil
 		},
@@ -367,11 +555,42 @@ func newAdhoc() *cobra.Command {
 	flags := cmd.Flags()
 	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
 	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
+	flags.StringVar(&format, "format", defaultFormat, "output format: "+choiceList(renderFormats()...))
+	flags.StringArrayVar(&positional, "arg", nil, "value to bind to the next ? parameter ("+argHelp+")")
+	flags.StringArrayVar(&named, "named", nil, "name=value to bind to the :name parameter")
 	flags.BoolVarP(&divs, "dividers", "l", false, "print lines between columns")
 	flags.BoolVarP(&headless, "no-header", "t", false, "don't print table header")
 	return cmd
 }
 
+// Return a new interactive shell command.
+func newShell() *cobra.Command {
+	var cluster []string
+	var dbName string
+	var history string
+
+	cmd := &cobra.Command{
+		Use:   "shell",
+		Short: "interactive sql shell against the database.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			logger := NewLogFunc(defaultLogLevel, "shell:: ", NewLoggingWriter())
+			sh, err := NewShell(ctx, &globalKeys, dbName, history, cluster, logger)
+			if err != nil {
+				return err
+			}
+			defer sh.Close()
+			return sh.Run()
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
+	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
+	flags.StringVarP(&history, "history", "y", envy.StringDefault("DQLITED_HISTORY", defaultHistory()), "file to persist command history")
+	return cmd
+}
+
 // Return a new database dump command.
 func newDumper() *cobra.Command {
 	var cluster []string
@@ -400,45 +619,163 @@ func newLoad() *cobra.Command {
 	var cluster []string
 	var dbName string
 	var fileName string
-	var batch, verbose bool
+	var verbose bool
+	var opts LoadOptions
+
+	cmd := &cobra.Command{
+		Use:   "load [file...]",
+		Short: "Execute the statements in the given files (- for stdin).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fileName != "" {
+				args = append([]string{fileName}, args...)
+			}
+			if len(args) == 0 {
+				return withExit(exitUsage, fmt.Errorf("no filename specified"))
+			}
+			if opts.Batch && (opts.Chunk > 0 || opts.Checkpoint != "" || opts.Rejects != "") {
+				return withExit(exitUsage, fmt.Errorf("--batch can't be combined with --chunk, --checkpoint, or --continue-on-error"))
+			}
+			cmd.SilenceUsage = true
+			ctx := context.Background()
+			return dbFile(ctx, &globalKeys, args, dbName, opts, verbose, cluster)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
+	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
+	flags.StringVarP(&fileName, "file", "f", "", "name of file to load (.gz and .zst files are decompressed)")
+	flags.BoolVarP(&opts.Batch, "batch", "b", false, "run all statements as a single transaction")
+	flags.IntVar(&opts.Chunk, "chunk", 0, "commit a transaction every N statements")
+	flags.StringVar(&opts.Checkpoint, "checkpoint", "", "file to record progress in, for resuming a chunked load")
+	flags.DurationVar(&opts.Progress, "progress", defaultProgress, "interval between progress reports for a chunked load (0 for none)")
+	flags.StringVar(&opts.Rejects, "continue-on-error", "", "continue past failing statements, writing them and their errors to this file")
+	flags.BoolVarP(&verbose, "verbose", "v", false, "be chatty about activities")
+
+	return cmd
+}
+
+// import a delimited text file into a table
+func newImport() *cobra.Command {
+	var cluster []string
+	var dbName string
+	var fileName string
+	var tsv, verbose bool
+	var opts ImportOptions
 
 	cmd := &cobra.Command{
-		Use:   "load",
-		Short: "Execute the statements in the given file.",
+		Use:   "import",
+		Short: "Import a CSV or TSV file into a table.",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if fileName == "" {
-				log.Fatal("no filename specified")
+				return fmt.Errorf("no filename specified")
+			}
+			if tsv {
+				opts.Comma = '\t'
 			}
+			opts.Infer = opts.Create
 			ctx := context.Background()
-			dbFile(ctx, &globalKeys, fileName, dbName, batch, verbose, cluster)
-			return nil
+			dx, err := NewConnection(ctx, &globalKeys, dbName, cluster, nil)
+			if err != nil {
+				return err
+			}
+			defer dx.Close()
+			dx.verbose = verbose
+			stats, err := dx.importFile(fileName, opts)
+			if stats != nil {
+				fmt.Println(stats)
+			}
+			return err
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
 	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
-	flags.StringVarP(&fileName, "file", "f", "", "name of file to load")
-	flags.BoolVarP(&batch, "batch", "b", false, "run all statements as a single transaction")
+	flags.StringVarP(&fileName, "file", "f", "", "name of file to import")
+	flags.StringVar(&opts.Table, "table", "", "name of table to import into")
+	flags.BoolVar(&opts.Create, "create", false, "create the table, inferring column types, if it does not exist")
+	flags.IntVar(&opts.Chunk, "chunk", defaultImportChunk, "number of rows to commit per transaction")
+	flags.StringVar(&opts.Rejects, "rejects", "", "file to write rows that fail to insert (default is to stop at the first failure)")
+	flags.BoolVar(&tsv, "tsv", false, "file is tab separated (default is by file extension)")
 	flags.BoolVarP(&verbose, "verbose", "v", false, "be chatty about activities")
 
 	return cmd
 }
 
+// export the results of a query (or the contents of a table)
+func newExport() *cobra.Command {
+	var cluster []string
+	var dbName string
+	var query, table string
+	var fileName, format string
+	var headless, types bool
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export query results as CSV, TSV, JSON, NDJSON, or Markdown.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case query != "" && table != "":
+				return fmt.Errorf("specify a query or a table, not both")
+			case table != "":
+				query = "select * from " + quoteIdent(table)
+			case query == "":
+				return fmt.Errorf("no query or table specified")
+			}
+			if format == "" {
+				format = formatFor(fileName, "csv")
+			}
+			ctx := context.Background()
+			dx, err := NewConnection(ctx, &globalKeys, dbName, cluster, nil)
+			if err != nil {
+				return err
+			}
+			defer dx.Close()
+			dx.header = !headless
+			dx.types = types
+			return dx.export(query, format, fileName)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
+	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
+	flags.StringVarP(&query, "query", "q", "", "query to export")
+	flags.StringVar(&table, "table", "", "table to export")
+	flags.StringVarP(&fileName, "output", "f", "", "file to write (default is stdout)")
+	flags.StringVar(&format, "format", "", "output format: "+choiceList(renderFormats()...)+" (default is by file extension, else csv)")
+	flags.BoolVar(&headless, "no-header", false, "don't write the column header (csv and tsv)")
+	flags.BoolVar(&types, "types", false, "annotate header columns with their declared types, e.g. id:INTEGER")
+
+	return cmd
+}
+
 // report a file containing multiple sql query statements
 func newReport() *cobra.Command {
 	var cluster []string
 	var dbName string
 	var fileName string
+	var format string
+	var positional, named []string
 	var headers, lines bool
 
 	cmd := &cobra.Command{
 		Use:   "query",
 		Short: "Execute the queries in in the given file.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if fileName == "" {
+				return withExit(exitUsage, fmt.Errorf("no filename specified"))
+			}
+			binds, err := bindArgs(positional, named)
+			if err != nil {
+				return withExit(exitUsage, err)
+			}
+			cmd.SilenceUsage = true
 			ctx := context.Background()
-			dbReport(ctx, &globalKeys, fileName, dbName, headers, lines, cluster)
-			return nil
+			return dbReport(ctx, &globalKeys, fileName, dbName, format, headers, lines, cluster, binds...)
 		},
 	}
 
@@ -446,12 +783,217 @@ func newReport() *cobra.Command {
 	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
 	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
 	flags.StringVarP(&fileName, "file", "f", "", "name of file to load")
+	flags.StringVar(&format, "format", defaultFormat, "output format: "+choiceList(renderFormats()...))
+	flags.StringArrayVar(&positional, "arg", nil, "value to bind to the next ? parameter ("+argHelp+")")
+	flags.StringArrayVar(&named, "named", nil, "name=value to bind to the :name parameter")
 	flags.BoolVarP(&headers, "headers", "b", true, "show table headings")
 	flags.BoolVarP(&lines, "lines", "v", false, "print lines between columns")
 
 	return cmd
 }
 
+// convert dumps from other databases
+func newConvert() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "convert",
+		Short:       "Convert a dump from another database to a script for load.",
+		Annotations: map[string]string{noContext: ""},
+	}
+	cmd.AddCommand(newConvertPg())
+	cmd.AddCommand(newConvertMysql())
+	return cmd
+}
+
+// convert a postgres dump
+func newConvertPg() *cobra.Command {
+	var output string
+	var prefix, verbose bool
+
+	cmd := &cobra.Command{
+		Use:   "pg [dump.sql]",
+		Short: "Convert the plain text output of pg_dump (- or no file for stdin).",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input := stdinName
+			if len(args) > 0 {
+				input = args[0]
+			}
+			cmd.SilenceUsage = true
+			return convertFile(newPgConverter(prefix, verbose), input, output)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&output, "output", "f", "", "file to write (default is stdout)")
+	flags.BoolVar(&prefix, "schema-prefix", false, "prefix table names with their schema, other than public")
+	flags.BoolVarP(&verbose, "verbose", "v", false, "report the statements that are skipped")
+
+	return cmd
+}
+
+// convert a mysql dump
+func newConvertMysql() *cobra.Command {
+	var output string
+	var verbose bool
+
+	cmd := &cobra.Command{
+		Use:   "mysql [dump.sql]",
+		Short: "Convert the output of mysqldump (- or no file for stdin).",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input := stdinName
+			if len(args) > 0 {
+				input = args[0]
+			}
+			cmd.SilenceUsage = true
+			return convertFile(newMysqlConverter(verbose), input, output)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&output, "output", "f", "", "file to write (default is stdout)")
+	flags.BoolVarP(&verbose, "verbose", "v", false, "report the statements that are skipped")
+
+	return cmd
+}
+
+// manage schema migrations
+func newMigrate() *cobra.Command {
+	var cluster []string
+	var dbName string
+	var dir string
+	var verbose bool
+
+	// run connects and applies the action, once the arguments are valid
+	run := func(cmd *cobra.Command, action func(*DBX) error) error {
+		cmd.SilenceUsage = true
+		ctx := context.Background()
+		return dbMigrate(ctx, &globalKeys, dbName, verbose, cluster, action)
+	}
+	// count parses an optional number of migrations
+	count := func(args []string, value int) (int, error) {
+		if len(args) == 0 {
+			return value, nil
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, withExit(exitUsage, fmt.Errorf("invalid count: %s", args[0]))
+		}
+		return n, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply versioned schema changes from a directory of sql files.",
+	}
+
+	up := &cobra.Command{
+		Use:   "up [count]",
+		Short: "Apply pending migrations, all of them unless count is given.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := count(args, 0)
+			if err != nil {
+				return err
+			}
+			return run(cmd, func(dx *DBX) error { return dx.migrateUp(dir, n) })
+		},
+	}
+
+	down := &cobra.Command{
+		Use:   "down [count]",
+		Short: "Revert the most recent migrations, one unless count is given.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := count(args, 1)
+			if err != nil {
+				return err
+			}
+			return run(cmd, func(dx *DBX) error { return dx.migrateDown(dir, n) })
+		},
+	}
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show which migrations have been applied.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd, func(dx *DBX) error { return dx.migrateStatus(dir) })
+		},
+	}
+
+	create := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create the up and down files for a new migration.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			files, err := createMigration(dir, args[0])
+			for _, file := range files {
+				fmt.Println("created:", file)
+			}
+			return err
+		},
+	}
+
+	cmd.AddCommand(up, down, status, create)
+
+	flags := cmd.PersistentFlags()
+	flags.StringSliceVarP(&cluster, "cluster", "c", clusterList(), "addresses of existing cluster nodes")
+	flags.StringVarP(&dbName, "database", "d", envy.StringDefault("DQLITED_DB", defaultDatabase), "name of database to use")
+	flags.StringVarP(&dir, "dir", "m", envy.StringDefault("DQLITED_MIGRATIONS", defaultMigrations), "directory of migration files")
+	flags.BoolVarP(&verbose, "verbose", "v", false, "print the statements as they are applied")
+
+	return cmd
+}
+
+// manage the contexts of the config file
+func newConfig(configFile *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "config",
+		Short:       "Manage the cluster contexts of the config file.",
+		Annotations: map[string]string{noContext: ""},
+	}
+
+	contexts := &cobra.Command{
+		Use:   "contexts",
+		Short: "List the contexts, marking the current one.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := readConfig(*configFile)
+			if err != nil {
+				return err
+			}
+			for _, name := range cfg.Names() {
+				mark := " "
+				if name == cfg.CurrentContext {
+					mark = "*"
+				}
+				fmt.Printf("%s %s\t%s\n", mark, name, strings.Join(cfg.Contexts[name].Cluster, ","))
+			}
+			return nil
+		},
+	}
+
+	use := &cobra.Command{
+		Use:   "use <context>",
+		Short: "Set the current context.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := readConfig(*configFile)
+			if err != nil {
+				return err
+			}
+			if _, _, err := cfg.Context(args[0]); err != nil {
+				return withExit(exitUsage, err)
+			}
+			cfg.CurrentContext = args[0]
+			return cfg.save(*configFile)
+		},
+	}
+
+	cmd.AddCommand(contexts, use)
+	return cmd
+}
+
 // run a load test against the database
 func newHammer() *cobra.Command {
 	var cluster []string
@@ -483,8 +1025,9 @@ func newHammer() *cobra.Command {
 // show the application version and exit
 func newVersion() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "version",
-		Short: "show build version",
+		Use:         "version",
+		Short:       "show build version",
+		Annotations: map[string]string{noContext: ""},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			fmt.Println(version)
 			return nil
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/paulstuart/dqlited/script"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultMigrations is the directory of migration files
+	defaultMigrations = "migrations"
+
+	// migrationsTable records the migrations that have been applied
+	migrationsTable = "schema_migrations"
+)
+
+// sqlite result codes for a write that conflicts with another
+const (
+	sqliteBusy       = 5
+	sqliteLocked     = 6
+	sqliteConstraint = 19
+)
+
+// migrationFile matches the names of migration files, e.g., 0001_add_users.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a versioned change to the schema, with an
+// "up" file that applies it and a "down" file that reverts it
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string // file names
+	Down     string
+	Checksum string // of the up file
+}
+
+// appliedMigration is a row of the migrations table
+type appliedMigration struct {
+	Version  int64
+	Name     string
+	Checksum string
+	Applied  string
+}
+
+// readMigrations returns the migrations in the directory, ordered by version
+func readMigrations(dir string) ([]*Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read migrations directory: %s", dir)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		match := migrationFile.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid version for file: %s", file.Name())
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+		path := filepath.Join(dir, file.Name())
+		if match[3] == "down" {
+			m.Down = path
+			continue
+		}
+		m.Up = path
+		if m.Checksum, err = fileChecksum(path); err != nil {
+			return nil, err
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// fileChecksum returns the sha256 of the file contents
+func fileChecksum(fileName string) (string, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// createMigration creates empty up and down files for a new
+// migration, versioned by the current time, and returns their names
+func createMigration(dir, name string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(name))
+	if name = strings.Trim(name, "_"); name == "" {
+		return nil, fmt.Errorf("migration name must include letters or digits")
+	}
+	version := time.Now().UTC().Format("20060102150405")
+	var files []string
+	for _, direction := range []string{"up", "down"} {
+		file := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+		text := fmt.Sprintf("-- %s migration %s_%s\n", direction, version, name)
+		if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// initMigrations creates the migrations table if it does not exist
+func (dx *DBX) initMigrations() error {
+	_, err := dx.exec(`CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+    version  INTEGER PRIMARY KEY,
+    name     TEXT NOT NULL,
+    checksum TEXT NOT NULL,
+    applied  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`)
+	return errors.Wrap(err, "can't create migrations table")
+}
+
+// appliedMigrations returns the migrations that have been applied, by version
+func (dx *DBX) appliedMigrations() (map[int64]appliedMigration, error) {
+	rows, err := dx.db.Query("select version, name, checksum, applied from " + migrationsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read migrations table")
+	}
+	defer rows.Close()
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var am appliedMigration
+		if err := rows.Scan(&am.Version, &am.Name, &am.Checksum, &am.Applied); err != nil {
+			return nil, errors.Wrap(err, "failed to scan migration")
+		}
+		applied[am.Version] = am
+	}
+	return applied, rows.Err()
+}
+
+// checkDrift returns an error if an applied migration has since been changed
+func checkDrift(migrations []*Migration, applied map[int64]appliedMigration) error {
+	var changed []string
+	for _, m := range migrations {
+		if am, ok := applied[m.Version]; ok && am.Checksum != m.Checksum {
+			changed = append(changed, fmt.Sprintf("%d_%s", m.Version, m.Name))
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("applied migrations have been modified: %s", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// checkOrder returns an error if a pending migration is older than the
+// newest applied one, as it was likely written before that was applied
+// and applying it now could run it against a schema it doesn't expect
+func checkOrder(migrations []*Migration, applied map[int64]appliedMigration) error {
+	var newest int64
+	for version := range applied {
+		if version > newest {
+			newest = version
+		}
+	}
+	var pending []string
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok && m.Version < newest {
+			pending = append(pending, fmt.Sprintf("%d_%s", m.Version, m.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations are older than the newest applied (%d), renumber them to apply: %s",
+			newest, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// migrateUp applies up to limit pending migrations (all of them if limit < 1), in order
+func (dx *DBX) migrateUp(dir string, limit int) error {
+	migrations, err := readMigrations(dir)
+	if err != nil {
+		return err
+	}
+	if err := dx.initMigrations(); err != nil {
+		return err
+	}
+	applied, err := dx.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	if err := checkDrift(migrations, applied); err != nil {
+		return err
+	}
+	if err := checkOrder(migrations, applied); err != nil {
+		return err
+	}
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if limit > 0 && count == limit {
+			break
+		}
+		if err := dx.applyMigration(m, true); err != nil {
+			return err
+		}
+		fmt.Fprintf(dx.w, "applied: %d_%s\n", m.Version, m.Name)
+		count++
+	}
+	if count == 0 {
+		fmt.Fprintln(dx.w, "no migrations to apply")
+	}
+	return nil
+}
+
+// migrateDown reverts the most recently applied migrations, limit of them
+func (dx *DBX) migrateDown(dir string, limit int) error {
+	migrations, err := readMigrations(dir)
+	if err != nil {
+		return err
+	}
+	if err := dx.initMigrations(); err != nil {
+		return err
+	}
+	applied, err := dx.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	if err := checkDrift(migrations, applied); err != nil {
+		return err
+	}
+	byVersion := make(map[int64]*Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if limit > len(versions) {
+		limit = len(versions)
+	}
+	for _, version := range versions[:limit] {
+		m, ok := byVersion[version]
+		if !ok {
+			return fmt.Errorf("no files for applied migration %d_%s", version, applied[version].Name)
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+		if err := dx.applyMigration(m, false); err != nil {
+			return err
+		}
+		fmt.Fprintf(dx.w, "reverted: %d_%s\n", m.Version, m.Name)
+	}
+	if limit == 0 {
+		fmt.Fprintln(dx.w, "no migrations to revert")
+	}
+	return nil
+}
+
+// applyMigration applies (or reverts) a migration as a single transaction.
+//
+// The migrations table is updated first, as all writes go through the leader
+// and are serialized, it is a lock across the cluster. A second process
+// attempting the same migration will fail on the primary key when adding
+// its row (or find no row to remove) and roll back without making changes.
+func (dx *DBX) applyMigration(m *Migration, up bool) error {
+	fileName := m.Up
+	if !up {
+		fileName = m.Down
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	statements, err := script.SplitStatements(string(b))
+	if err != nil {
+		return errors.Wrapf(err, "can't read migration: %s", fileName)
+	}
+	for _, stmt := range statements {
+		if script.TxControl(stmt) != "" {
+			return fmt.Errorf("migration %s can't control transactions: %s", fileName, stmt)
+		}
+	}
+
+	tx, err := dx.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "can't start transaction")
+	}
+	if up {
+		_, err = tx.Exec("insert into "+migrationsTable+" (version, name, checksum) values(?,?,?)", m.Version, m.Name, m.Checksum)
+	} else {
+		var result sql.Result
+		result, err = tx.Exec("delete from "+migrationsTable+" where version=?", m.Version)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n == 0 {
+				tx.Rollback()
+				return fmt.Errorf("migration %d_%s was reverted by another process", m.Version, m.Name)
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		if isConflict(err) {
+			return errors.Wrapf(err, "migration %d_%s is being changed by another process", m.Version, m.Name)
+		}
+		return errors.Wrapf(err, "can't update %s for migration %d_%s", migrationsTable, m.Version, m.Name)
+	}
+	for _, stmt := range statements {
+		if dx.verbose {
+			fmt.Fprintln(dx.w, stmt)
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "migration %s failed", fileName)
+		}
+	}
+	return errors.Wrapf(tx.Commit(), "can't commit migration: %s", fileName)
+}
+
+// isConflict reports whether sqlite rejected a write for a constraint or
+// a lock, as when another process is applying the same migration
+func isConflict(err error) bool {
+	derr, ok := errors.Cause(err).(driver.Error)
+	if !ok {
+		return false
+	}
+	switch derr.Code & 0xff { // the primary code of an extended one
+	case sqliteBusy, sqliteLocked, sqliteConstraint:
+		return true
+	}
+	return false
+}
+
+// migrateStatus shows each migration and whether it has been applied
+func (dx *DBX) migrateStatus(dir string) error {
+	migrations, err := readMigrations(dir)
+	if err != nil {
+		return err
+	}
+	if err := dx.initMigrations(); err != nil {
+		return err
+	}
+	applied, err := dx.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	table := newTable(dx.w, RenderOptions{Header: true})
+	defer table.Close()
+	table.Header([]string{"version", "name", "status", "applied"})
+	for _, m := range migrations {
+		status, when := "pending", ""
+		if am, ok := applied[m.Version]; ok {
+			status, when = "applied", am.Applied
+			if am.Checksum != m.Checksum {
+				status = "modified"
+			}
+			delete(applied, m.Version)
+		}
+		table.Body([]interface{}{m.Version, m.Name, status, when})
+	}
+	// applied migrations whose files are gone
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	for _, version := range versions {
+		am := applied[version]
+		table.Body([]interface{}{am.Version, am.Name, "missing", am.Applied})
+	}
+	return nil
+}
+
+// dbMigrate connects to the database and runs the migration action
+func dbMigrate(ctx context.Context, kp *KeyPair, dbname string, verbose bool, cluster []string, action func(*DBX) error) error {
+	dx, err := NewConnection(ctx, kp, dbname, cluster, nil)
+	if err != nil {
+		return withExit(exitConnect, err)
+	}
+	defer dx.Close()
+	dx.verbose = verbose
+	return action(dx)
+}
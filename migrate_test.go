@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, text := range map[string]string{
+		"0002_add_email.up.sql":   "alter table users add email text;",
+		"0001_add_users.up.sql":   "create table users (id integer);",
+		"0001_add_users.down.sql": "drop table users;",
+		"README.md":               "not a migration",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	migrations, err := readMigrations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("got %d migrations, want 2", len(migrations))
+	}
+	first, second := migrations[0], migrations[1]
+	if first.Version != 1 || first.Name != "add_users" || first.Down == "" || first.Checksum == "" {
+		t.Errorf("unexpected first migration: %+v", first)
+	}
+	if second.Version != 2 || second.Name != "add_email" || second.Down != "" {
+		t.Errorf("unexpected second migration: %+v", second)
+	}
+
+	// a down file without its up file
+	if err := ioutil.WriteFile(filepath.Join(dir, "0003_orphan.down.sql"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMigrations(dir); err == nil || !strings.Contains(err.Error(), "no up file") {
+		t.Errorf("expected a missing up file error, got %v", err)
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files, err := createMigration(dir, "Add Users!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !strings.HasSuffix(files[0], "_add_users.up.sql") || !strings.HasSuffix(files[1], "_add_users.down.sql") {
+		t.Errorf("unexpected files: %v", files)
+	}
+	if _, err := createMigration(dir, "!!"); err == nil {
+		t.Error("expected an error for a name without letters or digits")
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	migrations := []*Migration{{Version: 1, Name: "a", Checksum: "x"}, {Version: 2, Name: "b", Checksum: "y"}}
+	if err := checkDrift(migrations, map[int64]appliedMigration{1: {Version: 1, Checksum: "x"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := checkDrift(migrations, map[int64]appliedMigration{1: {Version: 1, Checksum: "changed"}})
+	if err == nil || !strings.Contains(err.Error(), "1_a") {
+		t.Errorf("expected drift for 1_a, got %v", err)
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	migrations := []*Migration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}, {Version: 3, Name: "c"}}
+	if err := checkOrder(migrations, nil); err != nil {
+		t.Errorf("nothing applied: unexpected error: %v", err)
+	}
+	if err := checkOrder(migrations, map[int64]appliedMigration{1: {}, 2: {}}); err != nil {
+		t.Errorf("in order: unexpected error: %v", err)
+	}
+	err := checkOrder(migrations, map[int64]appliedMigration{1: {}, 3: {}})
+	if err == nil || !strings.Contains(err.Error(), "2_b") {
+		t.Errorf("expected 2_b to be out of order, got %v", err)
+	}
+}
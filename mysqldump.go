@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// mysqlTypes maps mysql types to sqlite types, with anything unlisted
+// (e.g., varchar, enum, set, json) stored as TEXT
+var mysqlTypes = map[string]string{
+	"tinyint":    "INTEGER",
+	"smallint":   "INTEGER",
+	"mediumint":  "INTEGER",
+	"int":        "INTEGER",
+	"integer":    "INTEGER",
+	"bigint":     "INTEGER",
+	"bit":        "INTEGER",
+	"year":       "INTEGER",
+	"bool":       "BOOLEAN",
+	"boolean":    "BOOLEAN",
+	"float":      "REAL",
+	"double":     "REAL",
+	"real":       "REAL",
+	"decimal":    "NUMERIC",
+	"numeric":    "NUMERIC",
+	"dec":        "NUMERIC",
+	"fixed":      "NUMERIC",
+	"date":       "DATE",
+	"datetime":   "DATETIME",
+	"timestamp":  "DATETIME",
+	"binary":     "BLOB",
+	"varbinary":  "BLOB",
+	"tinyblob":   "BLOB",
+	"blob":       "BLOB",
+	"mediumblob": "BLOB",
+	"longblob":   "BLOB",
+}
+
+// mysqlPrefixLength matches an index column with a prefix length, e.g. "name"(10)
+var mysqlPrefixLength = regexp.MustCompile(`^("(?:[^"]|"")*"|\w+)\s*\(\d+\)`)
+
+// mysqlConverter translates the output of mysqldump into a script for load.
+// Indexes are created after the data is loaded, as is done by mysqldump
+// itself, which disables keys while inserting.
+type mysqlConverter struct {
+	verbose bool
+	after   []string // indexes, which follow the data
+	data    *spool
+	skipped int
+}
+
+func newMysqlConverter(verbose bool) *mysqlConverter {
+	return &mysqlConverter{verbose: verbose}
+}
+
+func (mc *mysqlConverter) convert(r io.Reader, w io.Writer) error {
+	data, err := newSpool()
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	mc.data = data
+
+	dr := newDumpReader(r)
+	dr.backslash = true
+	for {
+		stmt, err := dr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := mc.statement(mysqlRewrite(stmt)); err != nil {
+			return err
+		}
+	}
+
+	convertHeader(w, "MySQL", mc.skipped)
+	fmt.Fprintln(w, "PRAGMA foreign_keys=OFF;")
+	fmt.Fprintln(w, "BEGIN TRANSACTION;")
+	if err := data.copyTo(w); err != nil {
+		return errors.Wrap(err, "can't copy data")
+	}
+	for _, stmt := range mc.after {
+		fmt.Fprintf(w, "%s;\n", stmt)
+	}
+	fmt.Fprintln(w, "COMMIT;")
+	return nil
+}
+
+// statement converts a statement, or notes why it can't be
+func (mc *mysqlConverter) statement(stmt string) error {
+	switch {
+	case hasPrefixFold(stmt, "CREATE", "TABLE"):
+		return mc.createTable(stmt)
+	case hasPrefixFold(stmt, "DROP", "TABLE"):
+		fmt.Fprintf(mc.data.w, "%s;\n", stmt)
+	case hasPrefixFold(stmt, "INSERT"), hasPrefixFold(stmt, "REPLACE"):
+		mc.insert(stmt)
+	default:
+		// LOCK TABLES, SET, USE, CREATE DATABASE, etc.
+		mc.skip(stmt)
+	}
+	return nil
+}
+
+func (mc *mysqlConverter) skip(stmt string) {
+	mc.skipped++
+	skipped(mc.verbose, stmt)
+}
+
+// createTable converts a table, moving its (non-unique) keys to separate
+// indexes, and dropping the table options (ENGINE, CHARSET, etc.)
+func (mc *mysqlConverter) createTable(stmt string) error {
+	words := fields(stmt)
+	i := 2
+	if len(words) > 5 && strings.EqualFold(words[2], "IF") {
+		i += 3 // IF NOT EXISTS
+	}
+	if len(words) <= i {
+		return fmt.Errorf("can't parse: %s", stmt)
+	}
+	name := words[i]
+	if j := strings.IndexByte(name, '('); j > 0 {
+		name = name[:j]
+	}
+	body, _, ok := parenthesized(stmt[strings.Index(stmt, name)+len(name):])
+	if !ok {
+		return fmt.Errorf("can't parse: %s", stmt)
+	}
+
+	var columns, constraints []string
+	var primary string  // single column primary key
+	autoIncrement := -1 // index of the auto increment column
+	var autoName string // and its name
+	for _, def := range splitList(body) {
+		words := fields(def)
+		if len(words) == 0 {
+			continue
+		}
+		switch strings.ToUpper(words[0]) {
+		case "PRIMARY":
+			keys := mysqlKeyColumns(def)
+			if len(keys) == 1 {
+				primary = keys[0]
+			}
+			constraints = append(constraints, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+			continue
+		case "UNIQUE":
+			constraints = append(constraints, "UNIQUE ("+strings.Join(mysqlKeyColumns(def), ", ")+")")
+			continue
+		case "KEY", "INDEX":
+			index := unquote(name) + "_" + unquote(words[1])
+			if strings.HasPrefix(words[1], "(") {
+				index = unquote(name) + "_" + fmt.Sprint(len(mc.after))
+			}
+			mc.after = append(mc.after, fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+				quoteIdent(index), name, strings.Join(mysqlKeyColumns(def), ", ")))
+			continue
+		case "FULLTEXT", "SPATIAL":
+			mc.skip(def)
+			continue
+		case "CONSTRAINT", "FOREIGN", "CHECK":
+			constraints = append(constraints, def)
+			continue
+		}
+		column, auto := mysqlColumn(words)
+		if auto {
+			autoIncrement, autoName = len(columns), words[0]
+		}
+		columns = append(columns, column)
+	}
+
+	if autoIncrement >= 0 && unquote(primary) == unquote(autoName) {
+		// sqlite only auto increments an INTEGER PRIMARY KEY column
+		columns[autoIncrement] = autoName + " INTEGER PRIMARY KEY AUTOINCREMENT" + mysqlNotNull(columns[autoIncrement])
+		for i, constraint := range constraints {
+			if strings.HasPrefix(constraint, "PRIMARY KEY") {
+				constraints = append(constraints[:i], constraints[i+1:]...)
+				break
+			}
+		}
+	}
+	defs := append(columns, constraints...)
+	fmt.Fprintf(mc.data.w, "CREATE TABLE %s (\n    %s\n);\n", name, strings.Join(defs, ",\n    "))
+	return nil
+}
+
+// mysqlNotNull returns the NOT NULL constraint of a column definition, if any
+func mysqlNotNull(def string) string {
+	if strings.Contains(strings.ToUpper(def), " NOT NULL") {
+		return " NOT NULL"
+	}
+	return ""
+}
+
+// mysqlKeyColumns returns the columns of a key, without any prefix lengths
+func mysqlKeyColumns(def string) []string {
+	inner, _, ok := parenthesized(def)
+	if !ok {
+		return nil
+	}
+	keys := splitList(inner)
+	for i, key := range keys {
+		keys[i] = mysqlPrefixLength.ReplaceAllString(key, "$1")
+	}
+	return keys
+}
+
+// mysqlColumn converts a column definition, already split into words,
+// reporting if it is an AUTO_INCREMENT column
+func mysqlColumn(words []string) (string, bool) {
+	typ := strings.ToLower(words[1])
+	if i := strings.IndexByte(typ, '('); i > 0 {
+		typ = typ[:i]
+	}
+	sqlType, ok := mysqlTypes[typ]
+	if !ok {
+		sqlType = "TEXT"
+	}
+	def := []string{words[0], sqlType}
+	auto := false
+	for i := 2; i < len(words); i++ {
+		switch word := strings.ToUpper(words[i]); word {
+		case "UNSIGNED", "SIGNED", "ZEROFILL", "PRECISION", "VISIBLE", "INVISIBLE":
+		case "AUTO_INCREMENT":
+			auto = true
+		case "CHARSET", "COLLATE", "COMMENT", "SRID", "COLUMN_FORMAT", "STORAGE":
+			i++
+		case "CHARACTER":
+			i += 2 // CHARACTER SET name
+		case "ON":
+			i += 2 // ON UPDATE CURRENT_TIMESTAMP
+		case "DEFAULT":
+			if i+1 < len(words) {
+				i++
+				def = append(def, "DEFAULT", mysqlDefault(words[i]))
+			}
+		case "KEY":
+			// UNIQUE KEY or PRIMARY KEY
+			if last := def[len(def)-1]; last != "UNIQUE" {
+				def = append(def, word)
+			}
+		default:
+			def = append(def, words[i])
+		}
+	}
+	column := strings.Join(def, " ")
+	if auto && strings.HasSuffix(column, " PRIMARY KEY") && sqlType == "INTEGER" {
+		column += " AUTOINCREMENT"
+	}
+	return column, auto
+}
+
+// mysqlDefault converts a default value, which sqlite
+// requires to be in parentheses unless it is a literal
+func mysqlDefault(value string) string {
+	upper := strings.ToUpper(value)
+	switch {
+	case strings.HasPrefix(upper, "CURRENT_TIMESTAMP"), strings.HasPrefix(upper, "NOW("):
+		return "CURRENT_TIMESTAMP"
+	case upper == "NULL", upper == "TRUE", upper == "FALSE":
+		return upper
+	case isNumeric(value), isQuoted(value, '\''), strings.HasPrefix(upper, "X'"):
+		return value
+	case strings.HasPrefix(value, "("):
+		return value
+	}
+	return "(" + value + ")"
+}
+
+// insert converts an insert, with an INSERT for each row of an extended insert
+func (mc *mysqlConverter) insert(stmt string) {
+	words := fields(stmt)
+	values := -1
+	for i, word := range words {
+		if strings.EqualFold(word, "VALUES") || strings.EqualFold(word, "VALUE") {
+			values = i
+			break
+		}
+	}
+	if values < 0 || strings.Contains(strings.ToUpper(stmt), " ON DUPLICATE KEY ") {
+		mc.skip(stmt)
+		return
+	}
+	verb := strings.ToUpper(words[0])
+	var target []string
+	for _, word := range words[1:values] {
+		switch strings.ToUpper(word) {
+		case "IGNORE":
+			verb = "INSERT OR IGNORE"
+		case "INTO", "LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY":
+		default:
+			target = append(target, word)
+		}
+	}
+	prefix := verb + " INTO " + strings.Join(target, " ") + " VALUES"
+	for _, row := range splitList(strings.Join(words[values+1:], " ")) {
+		fmt.Fprintf(mc.data.w, "%s%s;\n", prefix, row)
+	}
+}
+
+// mysqlRewrite converts the quoting of a mysql statement to that of sqlite:
+// `identifiers` are "identifiers", strings with backslash escapes become
+// standard sql strings (or BLOB literals if binary), and 0x hex literals
+// become X” BLOB literals
+func mysqlRewrite(stmt string) string {
+	var buf strings.Builder
+	binary := false // a _binary introducer preceded the string
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == '`':
+			end := quoteEnd(stmt, i)
+			buf.WriteString(quoteIdent(unquote(stmt[i:end])))
+			i = end
+		case c == '\'' || c == '"':
+			text, end := mysqlString(stmt, i)
+			if binary || strings.IndexByte(text, 0) >= 0 || !utf8.ValidString(text) {
+				buf.WriteString("X'" + hex.EncodeToString([]byte(text)) + "'")
+			} else {
+				buf.WriteString(quoteString(text))
+			}
+			binary = false
+			i = end
+		case isIdentStart(c):
+			end := quoteEnd(stmt, i)
+			word := stmt[i:end]
+			i = end
+			if word[0] == '_' && i < len(stmt) && (stmt[i] == '\'' || stmt[i] == ' ') {
+				// a character set introducer, e.g. _utf8mb4'text'
+				rest := strings.TrimLeft(stmt[i:], " ")
+				if strings.HasPrefix(rest, "'") || strings.HasPrefix(rest, `"`) {
+					binary = strings.EqualFold(word, "_binary")
+					i = len(stmt) - len(rest)
+					continue
+				}
+			}
+			if (word == "b" || word == "B") && i < len(stmt) && stmt[i] == '\'' {
+				// a bit value, e.g. b'101'
+				text, end := mysqlString(stmt, i)
+				n, _ := strconv.ParseUint(text, 2, 64)
+				buf.WriteString(strconv.FormatUint(n, 10))
+				i = end
+				continue
+			}
+			buf.WriteString(word)
+		case c == '0' && i+2 < len(stmt) && (stmt[i+1] == 'x' || stmt[i+1] == 'X') && (i == 0 || !isIdentChar(stmt[i-1])):
+			end := i + 2
+			for end < len(stmt) && strings.IndexByte("0123456789abcdefABCDEF", stmt[end]) >= 0 {
+				end++
+			}
+			buf.WriteString("X'" + stmt[i+2:end] + "'")
+			i = end
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// mysqlString returns the text of the string literal at i, with its
+// escapes decoded, and the position after it
+func mysqlString(stmt string, i int) (string, int) {
+	quote := stmt[i]
+	var buf strings.Builder
+	for i++; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case c == '\\' && i+1 < len(stmt):
+			i++
+			switch c = stmt[i]; c {
+			case '0':
+				buf.WriteByte(0)
+			case 'b':
+				buf.WriteByte('\b')
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case 'Z':
+				buf.WriteByte(0x1a)
+			case '%', '_':
+				// only escaped in LIKE patterns
+				buf.WriteByte('\\')
+				buf.WriteByte(c)
+			default:
+				buf.WriteByte(c)
+			}
+		case c == quote:
+			if i+1 < len(stmt) && stmt[i+1] == quote {
+				buf.WriteByte(c)
+				i++
+				continue
+			}
+			return buf.String(), i + 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), len(stmt)
+}
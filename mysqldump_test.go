@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMysqlConvert(t *testing.T) {
+	dump := "-- MySQL dump 10.13\n" +
+		"/*!40101 SET NAMES utf8mb4 */;\n" +
+		"DROP TABLE IF EXISTS `users`;\n" +
+		"CREATE TABLE `users` (\n" +
+		"  `id` int unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `email` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL COMMENT 'login; address',\n" +
+		"  `active` tinyint(1) NOT NULL DEFAULT '1',\n" +
+		"  `flags` bit(3) DEFAULT b'101',\n" +
+		"  `created` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  UNIQUE KEY `email` (`email`(100)),\n" +
+		"  KEY `idx_active` (`active`,`created`) USING BTREE,\n" +
+		"  FULLTEXT KEY `ft` (`email`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4;\n" +
+		"LOCK TABLES `users` WRITE;\n" +
+		"INSERT INTO `users` VALUES (1,'a@b.c',1,b'1',NULL),(2,'it\\'s \\\"q\\\"; \\\\ done\\n',0,NULL,'2020-01-01 00:00:00');\n" +
+		"INSERT IGNORE INTO `users` (`id`, `email`) VALUES (3,'x');\n" +
+		"UNLOCK TABLES;\n"
+
+	var out strings.Builder
+	if err := newMysqlConverter(false).convert(strings.NewReader(dump), &out); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, out.String(),
+		"-- 3 statements without a sqlite equivalent were skipped",
+		"BEGIN TRANSACTION;\nDROP TABLE IF EXISTS \"users\";\n",
+		"    \"id\" INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,\n",
+		"    \"email\" TEXT NOT NULL,\n",
+		"    \"active\" INTEGER NOT NULL DEFAULT '1',\n",
+		"    \"flags\" INTEGER DEFAULT 5,\n",
+		"    \"created\" DATETIME NULL DEFAULT CURRENT_TIMESTAMP,\n",
+		"    UNIQUE (\"email\")\n);",
+		"INSERT INTO \"users\" VALUES(1,'a@b.c',1,1,NULL);\n",
+		"INSERT INTO \"users\" VALUES(2,'it''s \"q\"; \\ done\n',0,NULL,'2020-01-01 00:00:00');\n",
+		"INSERT OR IGNORE INTO \"users\" (\"id\", \"email\") VALUES(3,'x');\n",
+		"CREATE INDEX \"users_idx_active\" ON \"users\" (\"active\", \"created\");\nCOMMIT;\n",
+	)
+	if strings.Contains(out.String(), "PRIMARY KEY (") {
+		t.Errorf("primary key constraint should be folded into the column:\n%s", out.String())
+	}
+}
+
+func TestMysqlColumnAutoIncrement(t *testing.T) {
+	column, auto := mysqlColumn(fields(`"n" bigint NOT NULL AUTO_INCREMENT PRIMARY KEY`))
+	if want := `"n" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT`; column != want || !auto {
+		t.Errorf("got %q (%t), want %q", column, auto, want)
+	}
+}
+
+func TestMysqlRewrite(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"`a``b`", "\"a`b\""},
+		{`'it\'s'`, `'it''s'`},
+		{`"double"`, `'double'`},
+		{`'tab\there'`, "'tab\there'"},
+		{`'50\%'`, `'50\%'`},
+		{"0x0102FF", "X'0102FF'"},
+		{"_binary 'ab'", "X'6162'"},
+		{"_utf8mb4'text'", "'text'"},
+		{"b'101'", "5"},
+		{`'nul\0'`, "X'6e756c00'"},
+	}
+	for _, test := range tests {
+		if got := mysqlRewrite(test.in); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestMysqlDefault(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"CURRENT_TIMESTAMP(6)", "CURRENT_TIMESTAMP"},
+		{"now()", "CURRENT_TIMESTAMP"},
+		{"null", "NULL"},
+		{"42", "42"},
+		{"'x'", "'x'"},
+		{"(rand())", "(rand())"},
+		{"uuid()", "(uuid())"},
+	}
+	for _, test := range tests {
+		if got := mysqlDefault(test.in); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,1015 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// pgTypes maps postgres types to sqlite types, with anything unlisted
+// (e.g., varchar, uuid, json, enums) stored as TEXT
+var pgTypes = map[string]string{
+	"smallint":                    "INTEGER",
+	"integer":                     "INTEGER",
+	"int":                         "INTEGER",
+	"bigint":                      "INTEGER",
+	"int2":                        "INTEGER",
+	"int4":                        "INTEGER",
+	"int8":                        "INTEGER",
+	"smallserial":                 "INTEGER",
+	"serial":                      "INTEGER",
+	"bigserial":                   "INTEGER",
+	"serial2":                     "INTEGER",
+	"serial4":                     "INTEGER",
+	"serial8":                     "INTEGER",
+	"real":                        "REAL",
+	"float":                       "REAL",
+	"float4":                      "REAL",
+	"float8":                      "REAL",
+	"double precision":            "REAL",
+	"numeric":                     "NUMERIC",
+	"decimal":                     "NUMERIC",
+	"boolean":                     "BOOLEAN",
+	"bool":                        "BOOLEAN",
+	"bytea":                       "BLOB",
+	"date":                        "DATE",
+	"timestamp":                   "DATETIME",
+	"timestamp without time zone": "DATETIME",
+	"timestamp with time zone":    "DATETIME",
+	"timestamptz":                 "DATETIME",
+}
+
+// pgNextvals and pgSetvals match the sequence named by nextval() and setval(), and the value set
+var (
+	pgNextvals = regexp.MustCompile(`(?i)nextval\(\s*'((?:[^']|'')+)'`)
+	pgSetvals  = regexp.MustCompile(`(?i)setval\(\s*'((?:[^']|'')+)'(?:::regclass)?\s*,\s*(\d+)(?:\s*,\s*(true|false))?\s*\)`)
+)
+
+// pgColumnKeywords end the type of a column definition
+var pgColumnKeywords = map[string]bool{
+	"CHECK":      true,
+	"COLLATE":    true,
+	"CONSTRAINT": true,
+	"DEFAULT":    true,
+	"GENERATED":  true,
+	"NOT":        true,
+	"NULL":       true,
+	"PRIMARY":    true,
+	"REFERENCES": true,
+	"UNIQUE":     true,
+}
+
+// pgCastWords can continue the name of a type in a cast, e.g., ::character varying
+var pgCastWords = map[string]bool{
+	"varying":   true,
+	"precision": true,
+	"with":      true,
+	"without":   true,
+	"time":      true,
+	"zone":      true,
+}
+
+// pgColumn is a column of a table being converted
+type pgColumn struct {
+	name        string // as written in the dump
+	pgType      string // normalized postgres type, e.g., "character varying"
+	constraints []string
+	serial      bool   // values come from a sequence
+	sequence    string // name of the sequence, if known
+	autoinc     bool   // the serial primary key, see pgTable.autoIncrement
+}
+
+func (c *pgColumn) String() string {
+	def := c.name + " " + pgSqliteType(c.pgType)
+	constraints := c.constraints
+	if c.autoinc {
+		def += " PRIMARY KEY AUTOINCREMENT"
+		constraints = withoutPrimaryKey(constraints)
+	}
+	if len(constraints) > 0 {
+		def += " " + strings.Join(constraints, " ")
+	}
+	return def
+}
+
+// primaryKey reports whether the column's constraints include PRIMARY KEY
+func (c *pgColumn) primaryKey() bool {
+	return len(withoutPrimaryKey(c.constraints)) < len(c.constraints)
+}
+
+// withoutPrimaryKey returns the column constraints (as words) less any PRIMARY KEY
+func withoutPrimaryKey(constraints []string) []string {
+	list := make([]string, 0, len(constraints))
+	for i := 0; i < len(constraints); i++ {
+		if strings.EqualFold(constraints[i], "PRIMARY") && i+1 < len(constraints) && strings.EqualFold(constraints[i+1], "KEY") {
+			i++
+			continue
+		}
+		list = append(list, constraints[i])
+	}
+	return list
+}
+
+// pgTable is a table being converted
+type pgTable struct {
+	name        string
+	columns     []*pgColumn
+	constraints []string
+}
+
+func (t *pgTable) column(name string) *pgColumn {
+	name = unquote(name)
+	for _, c := range t.columns {
+		if unquote(c.name) == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// autoIncrement makes a serial primary key of a single integer column an
+// INTEGER PRIMARY KEY AUTOINCREMENT, so sqlite continues its sequence as
+// postgres would rather than reusing the ids of deleted rows. It reports
+// whether the table has such a column.
+func (t *pgTable) autoIncrement() bool {
+	var key *pgColumn
+	constraint := -1
+	for _, c := range t.columns {
+		if c.primaryKey() {
+			key = c
+		}
+	}
+	if key == nil {
+		for i, def := range t.constraints {
+			upper := strings.ToUpper(def)
+			j := strings.Index(upper, "PRIMARY KEY")
+			if j < 0 || (j > 0 && !strings.HasPrefix(upper, "CONSTRAINT ")) {
+				continue
+			}
+			inner, _, ok := parenthesized(def[j:])
+			if columns := splitList(inner); ok && len(columns) == 1 {
+				key, constraint = t.column(columns[0]), i
+			}
+			break
+		}
+	}
+	if key == nil || !key.serial || pgSqliteType(key.pgType) != "INTEGER" {
+		return false
+	}
+	if constraint >= 0 {
+		t.constraints = append(t.constraints[:constraint], t.constraints[constraint+1:]...)
+	}
+	key.autoinc = true
+	return true
+}
+
+func (t *pgTable) String() string {
+	defs := make([]string, 0, len(t.columns)+len(t.constraints))
+	for _, c := range t.columns {
+		defs = append(defs, c.String())
+	}
+	defs = append(defs, t.constraints...)
+	return fmt.Sprintf("CREATE TABLE %s (\n    %s\n);", t.name, strings.Join(defs, ",\n    "))
+}
+
+// pgConverter translates the plain text output of pg_dump into a script for load.
+//
+// Tables are collected until the end of the dump, as pg_dump adds their
+// defaults and constraints with ALTER TABLE statements after creating them,
+// which sqlite does not support. Meanwhile the data is spooled to a file.
+type pgConverter struct {
+	verbose  bool
+	prefix   bool                // prefix table names with their (non-public) schema
+	schemas  map[string]bool     // known schemas, to remove from qualified names
+	tables   map[string]*pgTable // keyed by unquoted name
+	order    []*pgTable
+	after    []string // indexes and views, which follow the data
+	setvals  []pgSetval
+	data     *spool
+	skipped  int
+	inserted int
+}
+
+// pgSetval is a sequence's current value, set by pg_dump after the data
+type pgSetval struct {
+	sequence string
+	value    int64 // the last value used
+}
+
+func newPgConverter(prefix, verbose bool) *pgConverter {
+	return &pgConverter{
+		verbose: verbose,
+		prefix:  prefix,
+		schemas: map[string]bool{"public": true, "pg_catalog": true},
+		tables:  make(map[string]*pgTable),
+	}
+}
+
+func (pc *pgConverter) convert(r io.Reader, w io.Writer) error {
+	data, err := newSpool()
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	pc.data = data
+
+	dr := newDumpReader(r)
+	dr.dollar = true
+	for {
+		stmt, err := dr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hasPrefixFold(stmt, "COPY") {
+			if err := pc.copyData(dr, stmt); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := pc.statement(stmt); err != nil {
+			return err
+		}
+	}
+
+	convertHeader(w, "Postgres", pc.skipped)
+	fmt.Fprintln(w, "PRAGMA foreign_keys=OFF;")
+	fmt.Fprintln(w, "BEGIN TRANSACTION;")
+	sequences := make(map[string]*pgTable)
+	for _, table := range pc.order {
+		if table.autoIncrement() {
+			for _, c := range table.columns {
+				if c.autoinc {
+					sequences[c.sequenceName(table)] = table
+				}
+			}
+		}
+		fmt.Fprintln(w, table)
+	}
+	if err := data.copyTo(w); err != nil {
+		return errors.Wrap(err, "can't copy data")
+	}
+	for _, sv := range pc.setvals {
+		table, ok := sequences[sv.sequence]
+		if !ok {
+			pc.skipped++
+			skipped(pc.verbose, "setval for sequence "+sv.sequence)
+			continue
+		}
+		// sqlite won't reuse an id below the largest in the table, but the
+		// sequence also accounts for the ids of rows since deleted
+		name := quoteString(unquote(table.name))
+		fmt.Fprintf(w, "DELETE FROM sqlite_sequence WHERE name = %s;\n", name)
+		fmt.Fprintf(w, "INSERT INTO sqlite_sequence (name, seq) VALUES(%s, %d);\n", name, sv.value)
+	}
+	for _, stmt := range pc.after {
+		fmt.Fprintf(w, "%s;\n", stmt)
+	}
+	fmt.Fprintln(w, "COMMIT;")
+	return nil
+}
+
+// statement converts a statement, or notes why it can't be
+func (pc *pgConverter) statement(stmt string) error {
+	switch {
+	case hasPrefixFold(stmt, "CREATE", "SCHEMA"):
+		words := fields(stmt)
+		if hasPrefixFold(stmt, "CREATE", "SCHEMA", "IF", "NOT", "EXISTS") {
+			words = words[3:]
+		}
+		if len(words) > 2 {
+			pc.schemas[unquote(words[2])] = true
+		}
+	case hasPrefixFold(stmt, "CREATE", "TABLE"),
+		hasPrefixFold(stmt, "CREATE", "UNLOGGED", "TABLE"):
+		return pc.createTable(stmt)
+	case hasPrefixFold(stmt, "ALTER", "TABLE"):
+		return pc.alterTable(stmt)
+	case hasPrefixFold(stmt, "ALTER", "SEQUENCE"):
+		pc.alterSequence(stmt)
+	case hasPrefixFold(stmt, "SELECT") && pgSetvals.MatchString(stmt):
+		pc.setval(stmt)
+	case hasPrefixFold(stmt, "CREATE", "INDEX"),
+		hasPrefixFold(stmt, "CREATE", "UNIQUE", "INDEX"):
+		pc.createIndex(stmt)
+	case hasPrefixFold(stmt, "CREATE", "VIEW"),
+		hasPrefixFold(stmt, "CREATE", "OR", "REPLACE", "VIEW"):
+		view := pc.rewrite(stmt)
+		view = strings.Replace(view, "CREATE OR REPLACE VIEW", "CREATE VIEW", 1)
+		pc.after = append(pc.after, view)
+	case hasPrefixFold(stmt, "INSERT"):
+		// from pg_dump --inserts
+		fmt.Fprintf(pc.data.w, "%s;\n", pc.insert(pc.rewrite(stmt)))
+		pc.inserted++
+	default:
+		// settings, sequences, ownership, grants, comments, functions, etc.
+		pc.skip(stmt)
+	}
+	return nil
+}
+
+func (pc *pgConverter) skip(stmt string) {
+	pc.skipped++
+	skipped(pc.verbose, stmt)
+}
+
+// createTable collects the definition of a table. A table that INHERITS
+// from another is given its parent's columns, as sqlite has no inheritance.
+func (pc *pgConverter) createTable(stmt string) error {
+	words := fields(stmt)
+	i := 2
+	if strings.EqualFold(words[1], "UNLOGGED") {
+		i++
+	}
+	if len(words) > i+3 && strings.EqualFold(words[i], "IF") {
+		i += 3 // IF NOT EXISTS
+	}
+	if len(words) <= i {
+		return fmt.Errorf("can't parse: %s", stmt)
+	}
+	if hasPrefixFold(strings.Join(words[i+1:], " "), "PARTITION", "OF") {
+		pc.skip(stmt)
+		return nil
+	}
+	name := words[i]
+	if j := strings.IndexByte(name, '('); j > 0 {
+		name = name[:j]
+	}
+	body, rest, ok := parenthesized(stmt[strings.Index(stmt, name)+len(name):])
+	if !ok {
+		return fmt.Errorf("can't parse: %s", stmt)
+	}
+	table := &pgTable{name: pc.rewrite(name)}
+
+	if hasPrefixFold(rest, "INHERITS") {
+		parents, _, _ := parenthesized(rest)
+		for _, parent := range splitList(parents) {
+			p, ok := pc.tables[unquote(pc.rewrite(parent))]
+			if !ok {
+				return fmt.Errorf("table %s inherits from unknown table %s", name, parent)
+			}
+			for _, c := range p.columns {
+				inherited := *c
+				inherited.constraints = append([]string(nil), c.constraints...)
+				table.columns = append(table.columns, &inherited)
+			}
+			for _, constraint := range p.constraints {
+				if strings.Contains(strings.ToUpper(constraint), " CHECK") {
+					table.constraints = append(table.constraints, constraint)
+				}
+			}
+		}
+	}
+
+	for _, def := range splitList(body) {
+		words := fields(def)
+		if len(words) == 0 {
+			continue
+		}
+		switch strings.ToUpper(words[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			table.constraints = append(table.constraints, pc.constraint(def))
+			continue
+		case "EXCLUDE", "LIKE":
+			pc.skip(def)
+			continue
+		}
+		column := pc.column(words)
+		if existing := table.column(column.name); existing != nil {
+			// a child can redefine an inherited column
+			*existing = *column
+			continue
+		}
+		table.columns = append(table.columns, column)
+	}
+	pc.tables[unquote(table.name)] = table
+	pc.order = append(pc.order, table)
+	return nil
+}
+
+// column converts a column definition, already split into words
+func (pc *pgConverter) column(words []string) *pgColumn {
+	column := &pgColumn{name: words[0]}
+	i := 1
+	var typ []string
+	for ; i < len(words) && !pgColumnKeywords[strings.ToUpper(words[i])]; i++ {
+		typ = append(typ, words[i])
+	}
+	column.pgType = pgBaseType(strings.Join(typ, " "))
+	if strings.HasSuffix(column.pgType, "serial") || strings.HasPrefix(column.pgType, "serial") {
+		column.serial = true
+	}
+	for i < len(words) {
+		switch strings.ToUpper(words[i]) {
+		case "DEFAULT":
+			j := i + 2
+			for j < len(words) && !pgColumnKeywords[strings.ToUpper(words[j])] {
+				j++
+			}
+			pc.setDefault(column, strings.Join(words[i+1:j], " "))
+			i = j
+		case "COLLATE":
+			// postgres collations are not available
+			i += 2
+		case "GENERATED":
+			j := i + 1
+			for j < len(words) && !strings.EqualFold(words[j], "IDENTITY") && !strings.EqualFold(words[j], "STORED") {
+				j++
+			}
+			if j < len(words) && strings.EqualFold(words[j], "IDENTITY") {
+				column.serial = true
+				if j+1 < len(words) && strings.HasPrefix(words[j+1], "(") {
+					j++ // sequence options
+					pc.identity(column, words[j])
+				}
+			} else {
+				column.constraints = append(column.constraints, pc.rewrite(strings.Join(words[i:j+1], " ")))
+			}
+			i = j + 1
+		default:
+			column.constraints = append(column.constraints, pc.rewrite(words[i]))
+			i++
+		}
+	}
+	return column
+}
+
+// setDefault sets the default value of the column, where a
+// default from a sequence makes it a serial column instead
+func (pc *pgConverter) setDefault(column *pgColumn, expr string) {
+	if match := pgNextvals.FindStringSubmatch(expr); match != nil {
+		column.serial = true
+		column.sequence = pc.sequenceName(match[1])
+		return
+	}
+	if strings.Contains(strings.ToLower(expr), "nextval(") {
+		column.serial = true
+		return
+	}
+	for i, constraint := range column.constraints {
+		if strings.HasPrefix(constraint, "DEFAULT ") {
+			column.constraints = append(column.constraints[:i], column.constraints[i+1:]...)
+			break
+		}
+	}
+	column.constraints = append(column.constraints, "DEFAULT "+pc.defaultValue(expr))
+}
+
+// defaultValue converts a default expression, which sqlite
+// requires to be in parentheses unless it is a literal
+func (pc *pgConverter) defaultValue(expr string) string {
+	expr = pc.rewrite(expr)
+	upper := strings.ToUpper(expr)
+	switch upper {
+	case "TRUE":
+		return "1"
+	case "FALSE":
+		return "0"
+	case "NULL", "CURRENT_TIMESTAMP", "CURRENT_DATE", "CURRENT_TIME":
+		return upper
+	case "LOCALTIMESTAMP":
+		return "CURRENT_TIMESTAMP"
+	}
+	if isNumeric(expr) || isQuoted(expr, '\'') {
+		return expr
+	}
+	if strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+		return expr
+	}
+	return "(" + expr + ")"
+}
+
+// constraint converts a table constraint
+func (pc *pgConverter) constraint(def string) string {
+	def = pc.rewrite(def)
+	for _, unsupported := range []string{" NOT VALID", " NO INHERIT"} {
+		if i := strings.Index(strings.ToUpper(def), unsupported); i > 0 {
+			def = def[:i] + def[i+len(unsupported):]
+		}
+	}
+	return def
+}
+
+// alterTable applies the changes pg_dump makes after creating a table
+func (pc *pgConverter) alterTable(stmt string) error {
+	words := fields(stmt)
+	i := 2
+	for i < len(words) && (strings.EqualFold(words[i], "ONLY") || strings.EqualFold(words[i], "IF") || strings.EqualFold(words[i], "EXISTS")) {
+		i++
+	}
+	if i+1 >= len(words) {
+		pc.skip(stmt)
+		return nil
+	}
+	table, ok := pc.tables[unquote(pc.rewrite(words[i]))]
+	if !ok {
+		pc.skip(stmt)
+		return nil
+	}
+	action := words[i+1:]
+	switch {
+	case hasPrefixFold(strings.Join(action, " "), "ADD", "CONSTRAINT"):
+		table.constraints = append(table.constraints, pc.constraint(strings.Join(action[1:], " ")))
+	case len(action) >= 4 && strings.EqualFold(action[0], "ALTER") && strings.EqualFold(action[1], "COLUMN"):
+		column := table.column(action[2])
+		if column == nil {
+			return fmt.Errorf("table %s has no column %s", table.name, action[2])
+		}
+		change := strings.Join(action[3:], " ")
+		switch {
+		case hasPrefixFold(change, "SET", "DEFAULT"):
+			pc.setDefault(column, strings.Join(action[5:], " "))
+		case hasPrefixFold(change, "SET", "NOT", "NULL"):
+			column.constraints = append(column.constraints, "NOT NULL")
+		case hasPrefixFold(change, "ADD", "GENERATED"):
+			column.serial = true
+			if last := action[len(action)-1]; strings.HasPrefix(last, "(") {
+				pc.identity(column, last)
+			}
+		default:
+			pc.skip(stmt)
+		}
+	default:
+		// ownership, triggers, replica identity, clustering, etc.
+		pc.skip(stmt)
+	}
+	return nil
+}
+
+// identity notes the sequence of an identity column from its options,
+// e.g. (SEQUENCE NAME public.t_id_seq START WITH 1 ...)
+func (pc *pgConverter) identity(column *pgColumn, options string) {
+	inner, _, _ := parenthesized(options)
+	words := fields(inner)
+	for i := 0; i+2 < len(words); i++ {
+		if strings.EqualFold(words[i], "SEQUENCE") && strings.EqualFold(words[i+1], "NAME") {
+			column.sequence = pc.sequenceName(words[i+2])
+			return
+		}
+	}
+}
+
+// alterSequence notes the column a sequence belongs to, e.g.
+// ALTER SEQUENCE public.t_id_seq OWNED BY public.t.id
+func (pc *pgConverter) alterSequence(stmt string) {
+	words := fields(stmt)
+	if len(words) != 6 || !strings.EqualFold(words[3], "OWNED") || !strings.EqualFold(words[4], "BY") {
+		pc.skip(stmt)
+		return
+	}
+	owner := words[5]
+	i := strings.LastIndexByte(owner, '.')
+	if i < 0 {
+		pc.skip(stmt)
+		return
+	}
+	table, ok := pc.tables[unquote(pc.rewrite(owner[:i]))]
+	if !ok {
+		pc.skip(stmt)
+		return
+	}
+	column := table.column(owner[i+1:])
+	if column == nil {
+		pc.skip(stmt)
+		return
+	}
+	column.sequence = pc.sequenceName(words[2])
+}
+
+// setval records the value pg_dump sets a sequence to, e.g.
+// SELECT pg_catalog.setval('public.t_id_seq', 42, true)
+func (pc *pgConverter) setval(stmt string) {
+	match := pgSetvals.FindStringSubmatch(stmt)
+	value, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		pc.skip(stmt)
+		return
+	}
+	if strings.EqualFold(match[3], "false") {
+		// the value is the next to be used, not the last
+		value--
+	}
+	pc.setvals = append(pc.setvals, pgSetval{sequence: pc.sequenceName(match[1]), value: value})
+}
+
+// sequenceName returns the name of a sequence as written in a
+// string literal (e.g. for nextval) or statement, without its schema
+func (pc *pgConverter) sequenceName(name string) string {
+	return unquote(pc.rewrite(strings.Replace(name, "''", "'", -1)))
+}
+
+// sequenceName returns the sequence of a serial column, where postgres
+// names it <table>_<column>_seq unless told otherwise
+func (c *pgColumn) sequenceName(table *pgTable) string {
+	if c.sequence != "" {
+		return c.sequence
+	}
+	return unquote(table.name) + "_" + unquote(c.name) + "_seq"
+}
+
+// createIndex converts an index, which can only use the default (btree) method
+func (pc *pgConverter) createIndex(stmt string) {
+	words := fields(stmt)
+	out := make([]string, 0, len(words))
+	for i := 0; i < len(words); i++ {
+		switch strings.ToUpper(words[i]) {
+		case "ONLY", "CONCURRENTLY":
+			continue
+		case "USING":
+			if i+1 < len(words) {
+				method := strings.ToLower(words[i+1])
+				if j := strings.IndexByte(method, '('); j > 0 {
+					// e.g. USING btree(name)
+					words[i+1] = words[i+1][j:]
+					method = method[:j]
+				} else {
+					i++
+				}
+				if method != "btree" && method != "hash" {
+					pc.skip(stmt)
+					return
+				}
+				continue
+			}
+		}
+		out = append(out, words[i])
+	}
+	pc.after = append(pc.after, pc.rewrite(strings.Join(out, " ")))
+}
+
+// copyData converts the rows of a COPY ... FROM stdin block to INSERT statements
+func (pc *pgConverter) copyData(dr *dumpReader, stmt string) error {
+	words := fields(stmt)
+	if len(words) < 4 || !strings.EqualFold(words[len(words)-1], "stdin") {
+		pc.skip(stmt)
+		return nil
+	}
+	name := pc.rewrite(words[1])
+	var columns []string
+	if inner, _, ok := parenthesized(stmt); ok {
+		columns = splitList(inner)
+	}
+	columns, types := pc.columnTypes(name, columns)
+	prefix := "INSERT INTO " + name + " VALUES("
+	if len(columns) > 0 {
+		prefix = fmt.Sprintf("INSERT INTO %s (%s) VALUES(", name, strings.Join(columns, ", "))
+	}
+
+	// the statement is followed by the rest of its line
+	if _, err := dr.readLine(); err != nil {
+		return err
+	}
+	for {
+		line, err := dr.readLine()
+		if err != nil {
+			return errors.Wrapf(err, "unterminated data for table %s", name)
+		}
+		if line == `\.` {
+			return nil
+		}
+		values := strings.Split(line, "\t")
+		if len(values) != len(columns) {
+			return fmt.Errorf("table %s has %d columns but the data has %d: %q", name, len(columns), len(values), line)
+		}
+		for i, value := range values {
+			values[i] = pgLiteral(value, types[i])
+		}
+		fmt.Fprintf(pc.data.w, "%s%s);\n", prefix, strings.Join(values, ","))
+		pc.inserted++
+	}
+}
+
+// columnTypes returns the postgres types of the columns of the table,
+// which are all of its columns if none are given
+func (pc *pgConverter) columnTypes(name string, columns []string) ([]string, []string) {
+	table, ok := pc.tables[unquote(name)]
+	if len(columns) == 0 && ok {
+		for _, c := range table.columns {
+			columns = append(columns, c.name)
+		}
+	}
+	types := make([]string, len(columns))
+	if ok {
+		for i, column := range columns {
+			if c := table.column(column); c != nil {
+				types[i] = c.pgType
+			}
+		}
+	}
+	return columns, types
+}
+
+// insert converts the timestamps of an INSERT from pg_dump --inserts,
+// as is done for COPY data, leaving the values of other columns as is
+func (pc *pgConverter) insert(stmt string) string {
+	words := fields(stmt)
+	if len(words) < 4 || !strings.EqualFold(words[1], "INTO") {
+		return stmt
+	}
+	name := words[2]
+	start := strings.Index(stmt, name) + len(name)
+	rest := strings.TrimSpace(stmt[start:])
+	var columns []string
+	if strings.HasPrefix(rest, "(") {
+		inner, after, _ := parenthesized(rest)
+		columns, rest = splitList(inner), after
+	}
+	columns, types := pc.columnTypes(name, columns)
+	timestamps := false
+	for _, typ := range types {
+		timestamps = timestamps || pgSqliteType(typ) == "DATETIME"
+	}
+	if !timestamps || len(rest) < 6 || !strings.EqualFold(rest[:6], "VALUES") {
+		return stmt
+	}
+	head := stmt[:len(stmt)-len(rest)+6]
+	rest = rest[6:]
+	var rows []string
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		inner, after, ok := parenthesized(rest)
+		values := splitList(inner)
+		if !ok || rest[0] != '(' || len(values) != len(types) {
+			// e.g., ON CONFLICT, which pg_dump only adds to be skipped
+			return stmt
+		}
+		for i, value := range values {
+			if pgSqliteType(types[i]) == "DATETIME" && isQuoted(value, '\'') {
+				values[i] = "'" + pgTimestamp(value[1:len(value)-1]) + "'"
+			}
+		}
+		rows = append(rows, "("+strings.Join(values, ",")+")")
+		rest = strings.TrimPrefix(after, ",")
+	}
+	return head + strings.Join(rows, ",")
+}
+
+// rewrite adapts postgres sql for sqlite by removing type casts (e.g. 'a'::text)
+// and schemas from qualified names (or prefixing them, for non-public schemas),
+// and replacing now() with CURRENT_TIMESTAMP
+func (pc *pgConverter) rewrite(text string) string {
+	var buf strings.Builder
+	prefix := ""
+	ident := func(name string) {
+		if prefix != "" {
+			if isQuoted(name, '"') {
+				name = `"` + prefix + name[1:]
+			} else {
+				name = prefix + name
+			}
+			prefix = ""
+		}
+		buf.WriteString(name)
+	}
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\'' || c == '$':
+			end := quoteEnd(text, i)
+			buf.WriteString(text[i:end])
+			i = end
+		case c == '"' || isIdentStart(c):
+			end := quoteEnd(text, i)
+			name := text[i:end]
+			i = end
+			if i < len(text) && text[i] == '.' && pc.schemas[unquote(name)] {
+				// a schema qualified name
+				i++
+				if pc.prefix && unquote(name) != "public" && unquote(name) != "pg_catalog" {
+					prefix = unquote(name) + "_"
+				}
+				continue
+			}
+			if strings.EqualFold(name, "now") && strings.HasPrefix(text[i:], "()") {
+				buf.WriteString("CURRENT_TIMESTAMP")
+				i += 2
+				continue
+			}
+			ident(name)
+		case c == ':' && strings.HasPrefix(text[i:], "::"):
+			i = skipCast(text, i+2)
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// skipCast returns the position after the type of a cast starting at i
+func skipCast(text string, i int) int {
+	word := func(i int) (int, string) {
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+		if i >= len(text) || (text[i] != '"' && !isIdentStart(text[i])) {
+			return i, ""
+		}
+		end := quoteEnd(text, i)
+		return end, text[i:end]
+	}
+	i, _ = word(i)
+	for i < len(text) && text[i] == '.' {
+		// schema qualified type
+		i, _ = word(i + 1)
+	}
+	for {
+		next, w := word(i)
+		if !pgCastWords[strings.ToLower(w)] {
+			break
+		}
+		i = next
+	}
+	if i < len(text) && text[i] == '(' {
+		if _, rest, ok := parenthesized(text[i:]); ok {
+			i = len(text) - len(rest)
+		}
+	}
+	for strings.HasPrefix(text[i:], "[]") {
+		i += 2
+	}
+	return i
+}
+
+// quoteEnd returns the position after the quoted text or identifier at i
+func quoteEnd(text string, i int) int {
+	switch c := text[i]; {
+	case c == '\'' || c == '"' || c == '`':
+		for j := i + 1; j < len(text); j++ {
+			if text[j] != c {
+				continue
+			}
+			if j+1 < len(text) && text[j+1] == c {
+				j++
+				continue
+			}
+			return j + 1
+		}
+		return len(text)
+	case c == '$':
+		// a dollar quote, e.g. $body$...$body$
+		j := i + 1
+		for j < len(text) && isIdentChar(text[j]) {
+			j++
+		}
+		if j >= len(text) || text[j] != '$' {
+			return j
+		}
+		tag := text[i : j+1]
+		if end := strings.Index(text[j+1:], tag); end >= 0 {
+			return j + 1 + end + len(tag)
+		}
+		return len(text)
+	}
+	j := i
+	for j < len(text) && isIdentChar(text[j]) {
+		j++
+	}
+	return j
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c))
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c == '$' || (c >= '0' && c <= '9')
+}
+
+// isQuoted reports whether text is entirely within the quote character
+func isQuoted(text string, quote byte) bool {
+	return len(text) >= 2 && text[0] == quote && quoteEnd(text, 0) == len(text)
+}
+
+// unquote returns an identifier without its quotes
+func unquote(name string) string {
+	switch {
+	case isQuoted(name, '"'):
+		return strings.Replace(name[1:len(name)-1], `""`, `"`, -1)
+	case isQuoted(name, '`'):
+		return strings.Replace(name[1:len(name)-1], "``", "`", -1)
+	}
+	return name
+}
+
+// pgBaseType normalizes a postgres type, without its size or precision
+func pgBaseType(typ string) string {
+	typ = strings.ToLower(typ)
+	if i := strings.IndexByte(typ, '('); i >= 0 {
+		if j := strings.IndexByte(typ[i:], ')'); j >= 0 {
+			typ = typ[:i] + typ[i+j+1:]
+		}
+	}
+	typ = strings.Join(strings.Fields(typ), " ")
+	typ = strings.TrimPrefix(typ, "pg_catalog.")
+	return strings.Trim(typ, `"`)
+}
+
+// pgSqliteType returns the sqlite type for a postgres type
+func pgSqliteType(typ string) string {
+	if strings.HasSuffix(typ, "[]") {
+		return "TEXT"
+	}
+	if sqlType, ok := pgTypes[typ]; ok {
+		return sqlType
+	}
+	return "TEXT"
+}
+
+// pgLiteral converts a field of COPY data to an sql literal for the column type
+func pgLiteral(value, typ string) string {
+	if value == `\N` {
+		return "NULL"
+	}
+	value = pgUnescape(value)
+	switch pgSqliteType(typ) {
+	case "INTEGER", "REAL", "NUMERIC":
+		if isNumeric(value) {
+			return value
+		}
+	case "BOOLEAN":
+		switch value {
+		case "t":
+			return "1"
+		case "f":
+			return "0"
+		}
+	case "BLOB":
+		if strings.HasPrefix(value, `\x`) {
+			return "X'" + value[2:] + "'"
+		}
+	case "DATETIME":
+		value = pgTimestamp(value)
+	}
+	return quoteString(value)
+}
+
+// pgTimestamp expands a utc offset of hours (+00) to hours
+// and minutes (+00:00), which is what sqlite expects
+func pgTimestamp(value string) string {
+	n := len(value)
+	if n > 3 && (value[n-3] == '+' || value[n-3] == '-') && strings.IndexByte(value, ' ') > 0 {
+		if _, err := strconv.Atoi(value[n-2:]); err == nil {
+			return value + ":00"
+		}
+	}
+	return value
+}
+
+// pgUnescape decodes the backslash escapes of COPY text format
+func pgUnescape(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\\' || i+1 == len(value) {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = value[i]; c {
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'v':
+			buf.WriteByte('\v')
+		case 'x':
+			j := i + 1
+			for j < len(value) && j < i+3 && strings.IndexByte("0123456789abcdefABCDEF", value[j]) >= 0 {
+				j++
+			}
+			if n, err := strconv.ParseUint(value[i+1:j], 16, 8); err == nil {
+				buf.WriteByte(byte(n))
+				i = j - 1
+				continue
+			}
+			buf.WriteByte(c)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(value) && j < i+3 && value[j] >= '0' && value[j] <= '7' {
+				j++
+			}
+			n, _ := strconv.ParseUint(value[i:j], 8, 8)
+			buf.WriteByte(byte(n))
+			i = j - 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
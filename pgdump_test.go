@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// pgConvert returns the converted dump, less its header comments
+func pgConvert(t *testing.T, dump string) string {
+	t.Helper()
+	var out strings.Builder
+	if err := newPgConverter(false, false).convert(strings.NewReader(dump), &out); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if !strings.HasPrefix(line, "--") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// expect fails unless each of the lines is in the output
+func expect(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(output, line) {
+			t.Errorf("missing %q in:\n%s", line, output)
+		}
+	}
+}
+
+func TestPgConvertSerial(t *testing.T) {
+	dump := `
+CREATE TABLE public.people (
+    id integer NOT NULL,
+    name character varying(255) DEFAULT 'anon'::character varying NOT NULL,
+    active boolean DEFAULT true
+);
+CREATE SEQUENCE public.people_id_seq AS integer START WITH 1 INCREMENT BY 1 CACHE 1;
+ALTER SEQUENCE public.people_id_seq OWNED BY public.people.id;
+ALTER TABLE ONLY public.people ALTER COLUMN id SET DEFAULT nextval('public.people_id_seq'::regclass);
+COPY public.people (id, name, active) FROM stdin;
+1	Bob	t
+2	\N	f
+\.
+SELECT pg_catalog.setval('public.people_id_seq', 7, true);
+ALTER TABLE ONLY public.people
+    ADD CONSTRAINT people_pkey PRIMARY KEY (id);
+`
+	out := pgConvert(t, dump)
+	expect(t, out,
+		"    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,",
+		"    name TEXT DEFAULT 'anon' NOT NULL,",
+		"    active BOOLEAN DEFAULT 1\n);",
+		"INSERT INTO people (id, name, active) VALUES(1,'Bob',1);",
+		"INSERT INTO people (id, name, active) VALUES(2,NULL,0);",
+		"DELETE FROM sqlite_sequence WHERE name = 'people';",
+		"INSERT INTO sqlite_sequence (name, seq) VALUES('people', 7);",
+	)
+	if strings.Contains(out, "people_pkey") {
+		t.Errorf("primary key constraint should be folded into the column:\n%s", out)
+	}
+}
+
+func TestPgConvertIdentity(t *testing.T) {
+	dump := `
+CREATE TABLE public.orders (
+    id bigint NOT NULL,
+    total numeric(10,2)
+);
+ALTER TABLE public.orders ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.orders_id_seq
+    START WITH 1
+);
+SELECT pg_catalog.setval('public.orders_id_seq', 10, false);
+ALTER TABLE ONLY public.orders ADD CONSTRAINT orders_pkey PRIMARY KEY (id);
+`
+	expect(t, pgConvert(t, dump),
+		"    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,",
+		"INSERT INTO sqlite_sequence (name, seq) VALUES('orders', 9);",
+	)
+}
+
+func TestPgConvertNotAutoIncrement(t *testing.T) {
+	// a composite key, and a serial column that isn't the key
+	dump := `
+CREATE TABLE public.pairs (
+    a integer NOT NULL,
+    b integer NOT NULL,
+    n serial,
+    PRIMARY KEY (a, b)
+);
+SELECT pg_catalog.setval('public.pairs_n_seq', 3, true);
+`
+	out := pgConvert(t, dump)
+	expect(t, out, "    n INTEGER,", "    PRIMARY KEY (a, b)")
+	if strings.Contains(out, "AUTOINCREMENT") || strings.Contains(out, "sqlite_sequence") {
+		t.Errorf("unexpected sequence:\n%s", out)
+	}
+}
+
+func TestPgConvertSchemasAndInherits(t *testing.T) {
+	dump := `
+CREATE SCHEMA sales;
+CREATE TABLE public.people (
+    id integer NOT NULL,
+    born timestamp with time zone DEFAULT now()
+);
+CREATE TABLE public.staff (
+    salary bigint
+)
+INHERITS (public.people);
+CREATE TABLE sales.orders (
+    person_id integer REFERENCES public.people(id),
+    placed date DEFAULT CURRENT_DATE
+);
+COPY public.people (id, born) FROM stdin;
+1	2020-01-02 03:04:05+00
+\.
+CREATE INDEX people_born ON public.people USING btree (born);
+`
+	expect(t, pgConvert(t, dump),
+		"CREATE TABLE people (\n    id INTEGER NOT NULL,\n    born DATETIME DEFAULT CURRENT_TIMESTAMP\n);",
+		"CREATE TABLE staff (\n    id INTEGER NOT NULL,\n    born DATETIME DEFAULT CURRENT_TIMESTAMP,\n    salary INTEGER\n);",
+		"CREATE TABLE orders (\n    person_id INTEGER REFERENCES people(id),\n    placed DATE DEFAULT CURRENT_DATE\n);",
+		"VALUES(1,'2020-01-02 03:04:05+00:00');",
+		"CREATE INDEX people_born ON people (born);",
+	)
+}
+
+func TestPgConvertInserts(t *testing.T) {
+	dump := `
+CREATE TABLE public.events (
+    id integer NOT NULL,
+    note text,
+    at timestamp with time zone
+);
+INSERT INTO public.events VALUES (1, '2020-01-02 03:04:05+00', '2020-01-02 03:04:05+00');
+INSERT INTO public.events (id, at) VALUES (2, '2020-01-02 03:04:05.5-07'), (3, NULL);
+`
+	expect(t, pgConvert(t, dump),
+		"INSERT INTO events VALUES(1,'2020-01-02 03:04:05+00','2020-01-02 03:04:05+00:00');",
+		"INSERT INTO events (id, at) VALUES(2,'2020-01-02 03:04:05.5-07:00'),(3,NULL);",
+	)
+}
+
+func TestPgLiteral(t *testing.T) {
+	tests := []struct {
+		value, typ, want string
+	}{
+		{`\N`, "integer", "NULL"},
+		{"42", "integer", "42"},
+		{"t", "boolean", "1"},
+		{"f", "bool", "0"},
+		{`\\x01ff`, "bytea", "X'01ff'"},
+		{"it's", "text", "'it''s'"},
+		{`a\tb`, "text", "'a\tb'"},
+		{"2020-01-02 03:04:05-07", "timestamp with time zone", "'2020-01-02 03:04:05-07:00'"},
+		{"{a,b}", "text[]", "'{a,b}'"},
+	}
+	for _, test := range tests {
+		if got := pgLiteral(test.value, test.typ); got != test.want {
+			t.Errorf("%q (%s): got %s, want %s", test.value, test.typ, got, test.want)
+		}
+	}
+}
+
+func TestPgRewrite(t *testing.T) {
+	pc := newPgConverter(true, false)
+	pc.schemas["sales"] = true
+	tests := []struct {
+		in, want string
+	}{
+		{"'a'::text", "'a'"},
+		{"x::character varying(10)", "x"},
+		{"public.people", "people"},
+		{"sales.orders", "sales_orders"},
+		{`sales."Orders"`, `"sales_Orders"`},
+		{"now()", "CURRENT_TIMESTAMP"},
+		{"'public.people::text'", "'public.people::text'"},
+	}
+	for _, test := range tests {
+		if got := pc.rewrite(test.in); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
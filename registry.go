@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/pkg/errors"
+)
+
+// clusterKey identifies a cluster and the credentials used to reach it
+type clusterKey struct {
+	cluster string // node addresses, sorted and comma separated
+	cert    string
+	key     string
+}
+
+// connKey identifies a database of a cluster
+type connKey struct {
+	clusterKey
+	dbName string
+}
+
+// dbConn is a database handle shared by every connection with the same key
+type dbConn struct {
+	db   *sql.DB
+	refs int
+}
+
+var (
+	dbxMu      sync.Mutex
+	dbxDrivers = make(map[clusterKey]string) // registered driver names
+	dbxDB      = make(map[connKey]*dbConn)
+)
+
+// newClusterKey returns the key for the cluster addresses and key pair
+func newClusterKey(pair *KeyPair, cluster []string) clusterKey {
+	if len(cluster) == 0 {
+		cluster = defaultCluster
+	}
+	addresses := append([]string{}, cluster...)
+	sort.Strings(addresses)
+	ck := clusterKey{cluster: strings.Join(addresses, ",")}
+	if pair != nil && pair.Cert != "" {
+		ck.cert, ck.key = absPath(pair.Cert), absPath(pair.Key)
+	}
+	return ck
+}
+
+// absPath returns the absolute path of the file, so the same
+// credentials are recognized regardless of how they were named
+func absPath(fileName string) string {
+	if path, err := filepath.Abs(fileName); err == nil {
+		return path
+	}
+	return fileName
+}
+
+// getDB returns the database handle for the cluster, shared with other
+// connections using the same cluster and credentials. Drivers can't be
+// unregistered, so each cluster/credential pairing registers its own
+// once and it is reused for the life of the process. The logger of
+// the first connection to a cluster is the one used by its driver.
+func getDB(ctx context.Context, pair *KeyPair, dbName string, cluster []string, logger client.LogFunc) (*sql.DB, connKey, error) {
+	dbxMu.Lock()
+	defer dbxMu.Unlock()
+
+	ck := newClusterKey(pair, cluster)
+	key := connKey{clusterKey: ck, dbName: dbName}
+	if conn, ok := dbxDB[key]; ok {
+		conn.refs++
+		return conn.db, key, nil
+	}
+
+	driverName, ok := dbxDrivers[ck]
+	if !ok {
+		dbDriver, err := newDriver(ctx, pair, cluster, logger)
+		if err != nil {
+			return nil, key, err
+		}
+		// the map only grows, so its size makes a unique name
+		driverName = fmt.Sprintf("dqlite-%d", len(dbxDrivers)+1)
+		sql.Register(driverName, dbDriver)
+		dbxDrivers[ck] = driverName
+	}
+
+	db, err := sql.Open(driverName, dbName)
+	if err != nil {
+		return nil, key, err
+	}
+	db.SetMaxOpenConns(1) // dqlite is single-threaded
+	dbxDB[key] = &dbConn{db: db, refs: 1}
+	return db, key, nil
+}
+
+// releaseDB closes the database handle once no connection is using it
+func releaseDB(key connKey) error {
+	dbxMu.Lock()
+	defer dbxMu.Unlock()
+	conn, ok := dbxDB[key]
+	if !ok {
+		return nil
+	}
+	if conn.refs--; conn.refs > 0 {
+		return nil
+	}
+	delete(dbxDB, key)
+	return conn.db.Close()
+}
+
+// newDriver creates a dqlite driver for the cluster, using TLS if a key pair is given
+func newDriver(ctx context.Context, pair *KeyPair, cluster []string, logger client.LogFunc) (*driver.Driver, error) {
+	if logger == nil {
+		logger = client.DefaultLogFunc
+	}
+	dial, err := dialFunc(pair)
+	if err != nil {
+		return nil, err
+	}
+	store, err := getStore(ctx, cluster, net.DefaultResolver, dial, logger)
+	if err != nil {
+		return nil, err
+	}
+	dbDriver, err := driver.New(store, driver.WithLogFunc(logger), driver.WithDialFunc(dial))
+	return dbDriver, errors.Wrapf(err, "failed to create dqlite driver")
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultFormat is the format used to display query results
+const defaultFormat = "table"
+
+// RenderOptions control the presentation of query results
+type RenderOptions struct {
+	Header   bool // show the column names
+	Dividers bool // print lines between columns (table format)
+}
+
+// renderers maps format names to their QDB constructors
+var renderers = map[string]func(w io.Writer, opts RenderOptions) QDB{
+	"table":    func(w io.Writer, opts RenderOptions) QDB { return newTable(w, opts) },
+	"column":   func(w io.Writer, opts RenderOptions) QDB { return newTable(w, RenderOptions{Header: opts.Header}) }, // table without dividers, as before
+	"box":      func(w io.Writer, opts RenderOptions) QDB { return newBox(w, opts.Header) },
+	"line":     func(w io.Writer, opts RenderOptions) QDB { return newLineRenderer(w) },
+	"csv":      func(w io.Writer, opts RenderOptions) QDB { return newDelimited(w, ',', opts.Header) },
+	"tsv":      func(w io.Writer, opts RenderOptions) QDB { return newDelimited(w, '\t', opts.Header) },
+	"json":     func(w io.Writer, opts RenderOptions) QDB { return newJSONRenderer(w, false) },
+	"ndjson":   func(w io.Writer, opts RenderOptions) QDB { return newJSONRenderer(w, true) },
+	"markdown": func(w io.Writer, opts RenderOptions) QDB { return newMarkdown(w) },
+}
+
+// renderFormats returns the names of the supported output formats
+func renderFormats() []string {
+	formats := make([]string, 0, len(renderers))
+	for format := range renderers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// NewRenderer returns a QDB that writes query results in the given format
+func NewRenderer(format string, w io.Writer, opts RenderOptions) (QDB, error) {
+	fn, ok := renderers[format]
+	if !ok {
+		return nil, fmt.Errorf("invalid format: %q -- must be %s", format, choiceList(renderFormats()...))
+	}
+	return fn(w, opts), nil
+}
+
+// formatFor returns the output format implied by the file name
+func formatFor(fileName, fallback string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	switch ext {
+	case "md":
+		return "markdown"
+	case "jsonl":
+		return "ndjson"
+	}
+	if _, ok := renderers[ext]; ok {
+		return ext
+	}
+	return fallback
+}
+
+// textValue renders a scanned value as text, reporting if it is NULL
+func textValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case []byte:
+		return hex.EncodeToString(v), false
+	case string:
+		return v, false
+	case int64:
+		return strconv.FormatInt(v, 10), false
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), false
+	case time.Time:
+		return v.Format(sqliteTimestamp), false
+	}
+	return fmt.Sprint(value), false
+}
+
+// displayValue renders a scanned value for reading rather than parsing
+func displayValue(value interface{}) string {
+	text, null := textValue(value)
+	if null {
+		return "NULL"
+	}
+	return text
+}
+
+// DBTW writes rows as aligned columns
+type DBTW struct {
+	tw     *tabwriter.Writer
+	header bool
+	multi  bool
+}
+
+func newTable(w io.Writer, opts RenderOptions) *DBTW {
+	flags := tabwriter.TabIndent
+	if opts.Dividers {
+		flags |= tabwriter.Debug
+	}
+	// tabwriter args: output, minwidth, tabwidth, padding, padchar, flags
+	tw := tabwriter.NewWriter(
+		w,     // io.Writer
+		0,     // min width
+		0,     // tab width
+		1,     // padding
+		' ',   // pad character
+		flags, // behavior flags
+	)
+	return &DBTW{tw: tw, header: opts.Header}
+}
+
+func (d *DBTW) Header(columns []string) {
+	// each result set is aligned independently
+	if d.multi {
+		d.tw.Flush()
+	}
+	d.multi = true
+	if !d.header {
+		return
+	}
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprint(d.tw, "\t")
+		}
+		fmt.Fprint(d.tw, column)
+	}
+	fmt.Fprintln(d.tw)
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprint(d.tw, "\t")
+		}
+		fmt.Fprint(d.tw, strings.Repeat("-", len(column)))
+	}
+	fmt.Fprintln(d.tw)
+}
+
+func (d *DBTW) Body(buffer []interface{}) {
+	for i, column := range buffer {
+		if i > 0 {
+			fmt.Fprint(d.tw, "\t")
+		}
+		fmt.Fprint(d.tw, displayValue(column))
+	}
+	fmt.Fprintln(d.tw)
+}
+
+func (d *DBTW) Close() {
+	d.tw.Flush()
+}
+
+// boxRenderer draws rows in a table with box drawing characters,
+// which requires holding each result set to size the columns
+type boxRenderer struct {
+	w       *bufio.Writer
+	header  bool
+	columns []string
+	rows    [][]string
+	widths  []int
+	multi   bool
+}
+
+func newBox(w io.Writer, header bool) *boxRenderer {
+	return &boxRenderer{w: bufio.NewWriter(w), header: header}
+}
+
+func (b *boxRenderer) Header(columns []string) {
+	if b.multi {
+		b.flush()
+		b.w.WriteString("\n")
+	}
+	b.multi = true
+	b.columns = columns
+	b.rows = b.rows[:0]
+	b.widths = make([]int, len(columns))
+	if b.header {
+		b.measure(columns)
+	}
+}
+
+func (b *boxRenderer) Body(buffer []interface{}) {
+	row := make([]string, len(buffer))
+	for i, value := range buffer {
+		row[i] = strings.Replace(displayValue(value), "\n", " ", -1)
+	}
+	b.measure(row)
+	b.rows = append(b.rows, row)
+}
+
+func (b *boxRenderer) measure(row []string) {
+	for i, text := range row {
+		if n := utf8.RuneCountInString(text); n > b.widths[i] {
+			b.widths[i] = n
+		}
+	}
+}
+
+func (b *boxRenderer) rule(left, middle, right string) {
+	b.w.WriteString(left)
+	for i, width := range b.widths {
+		if i > 0 {
+			b.w.WriteString(middle)
+		}
+		b.w.WriteString(strings.Repeat("─", width+2))
+	}
+	b.w.WriteString(right + "\n")
+}
+
+func (b *boxRenderer) line(row []string) {
+	for i, text := range row {
+		b.w.WriteString("│ " + text + strings.Repeat(" ", b.widths[i]-utf8.RuneCountInString(text)) + " ")
+	}
+	b.w.WriteString("│\n")
+}
+
+func (b *boxRenderer) flush() {
+	if b.columns == nil {
+		return
+	}
+	b.rule("┌", "┬", "┐")
+	if b.header {
+		b.line(b.columns)
+		b.rule("├", "┼", "┤")
+	}
+	for _, row := range b.rows {
+		b.line(row)
+	}
+	b.rule("└", "┴", "┘")
+}
+
+func (b *boxRenderer) Close() {
+	b.flush()
+	b.w.Flush()
+}
+
+// lineRenderer writes each column on its own line, as "name = value"
+type lineRenderer struct {
+	w       *bufio.Writer
+	columns []string
+	width   int
+	count   int
+}
+
+func newLineRenderer(w io.Writer) *lineRenderer {
+	return &lineRenderer{w: bufio.NewWriter(w)}
+}
+
+func (l *lineRenderer) Header(columns []string) {
+	l.columns = columns
+	l.width = 0
+	for _, column := range columns {
+		if n := utf8.RuneCountInString(column); n > l.width {
+			l.width = n
+		}
+	}
+}
+
+func (l *lineRenderer) Body(buffer []interface{}) {
+	if l.count > 0 {
+		l.w.WriteString("\n")
+	}
+	l.count++
+	for i, value := range buffer {
+		fmt.Fprintf(l.w, "%*s = %s\n", l.width, l.columns[i], displayValue(value))
+	}
+}
+
+func (l *lineRenderer) Close() {
+	l.w.Flush()
+}
+
+// delimited writes rows as CSV or TSV, distinguishing
+// NULL (an empty field) from the empty string ("")
+type delimited struct {
+	w      *bufio.Writer
+	comma  rune
+	header bool
+}
+
+func newDelimited(w io.Writer, comma rune, header bool) *delimited {
+	return &delimited{w: bufio.NewWriter(w), comma: comma, header: header}
+}
+
+func (d *delimited) Header(columns []string) {
+	if !d.header {
+		return
+	}
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column
+	}
+	d.Body(values)
+}
+
+func (d *delimited) Body(buffer []interface{}) {
+	for i, value := range buffer {
+		if i > 0 {
+			d.w.WriteRune(d.comma)
+		}
+		text, null := textValue(value)
+		if null {
+			continue
+		}
+		d.w.WriteString(d.field(text))
+	}
+	d.w.WriteString("\n")
+}
+
+// field quotes text as needed, per RFC 4180
+func (d *delimited) field(text string) string {
+	if text != "" && !strings.ContainsAny(text, "\"\r\n"+string(d.comma)) && text[0] != ' ' {
+		return text
+	}
+	return `"` + strings.Replace(text, `"`, `""`, -1) + `"`
+}
+
+func (d *delimited) Close() {
+	d.w.Flush()
+}
+
+// jsonRenderer writes rows as JSON objects, either as a
+// single array or as newline delimited objects
+type jsonRenderer struct {
+	w       *bufio.Writer
+	lines   bool
+	columns [][]byte // column names, pre-encoded
+	count   int
+}
+
+func newJSONRenderer(w io.Writer, lines bool) *jsonRenderer {
+	return &jsonRenderer{w: bufio.NewWriter(w), lines: lines}
+}
+
+func (j *jsonRenderer) Header(columns []string) {
+	j.columns = make([][]byte, len(columns))
+	for i, column := range columns {
+		j.columns[i], _ = json.Marshal(column)
+	}
+}
+
+func (j *jsonRenderer) Body(buffer []interface{}) {
+	switch {
+	case j.lines:
+	case j.count == 0:
+		j.w.WriteString("[\n")
+	default:
+		j.w.WriteString(",\n")
+	}
+	j.count++
+	j.w.WriteByte('{')
+	for i, value := range buffer {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.columns[i])
+		j.w.WriteByte(':')
+		j.w.Write(jsonValue(value))
+	}
+	j.w.WriteByte('}')
+	if j.lines {
+		j.w.WriteByte('\n')
+	}
+}
+
+func (j *jsonRenderer) Close() {
+	if !j.lines {
+		if j.count == 0 {
+			j.w.WriteString("[")
+		}
+		j.w.WriteString("\n]\n")
+	}
+	j.w.Flush()
+}
+
+// jsonValue encodes a scanned value, with BLOBs as base64 strings
+func jsonValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return []byte("null")
+		}
+	case time.Time:
+		value = v.Format(sqliteTimestamp)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return b
+}
+
+// markdown writes rows as a GitHub flavored markdown table
+type markdown struct {
+	w     *bufio.Writer
+	multi bool
+}
+
+func newMarkdown(w io.Writer) *markdown {
+	return &markdown{w: bufio.NewWriter(w)}
+}
+
+func (m *markdown) Header(columns []string) {
+	if m.multi {
+		m.w.WriteString("\n")
+	}
+	m.multi = true
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column
+	}
+	m.Body(values)
+	for range columns {
+		m.w.WriteString("|---")
+	}
+	m.w.WriteString("|\n")
+}
+
+func (m *markdown) Body(buffer []interface{}) {
+	for _, value := range buffer {
+		text := displayValue(value)
+		text = strings.Replace(text, "|", `\|`, -1)
+		text = strings.Replace(text, "\n", "<br>", -1)
+		m.w.WriteString("| " + text + " ")
+	}
+	m.w.WriteString("|\n")
+}
+
+func (m *markdown) Close() {
+	m.w.Flush()
+}
+
+var (
+	_ QDB = (*DBTW)(nil)
+	_ QDB = (*boxRenderer)(nil)
+	_ QDB = (*lineRenderer)(nil)
+	_ QDB = (*delimited)(nil)
+	_ QDB = (*jsonRenderer)(nil)
+	_ QDB = (*markdown)(nil)
+)
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// render returns the output of the format for a result set
+// with a NULL, a BLOB, and text that needs quoting
+func render(t *testing.T, format string, opts RenderOptions) string {
+	t.Helper()
+	var out strings.Builder
+	r, err := NewRenderer(format, &out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header([]string{"id", "name", "data"})
+	r.Body([]interface{}{int64(1), "Bob", nil})
+	r.Body([]interface{}{int64(22), `a, "b"`, []byte{0xab}})
+	r.Close()
+	return out.String()
+}
+
+// lines joins the lines of the expected output
+func lines(text ...string) string {
+	return strings.Join(text, "\n") + "\n"
+}
+
+func TestRenderers(t *testing.T) {
+	tests := []struct {
+		format string
+		opts   RenderOptions
+		want   string
+	}{
+		{"csv", RenderOptions{Header: true}, lines(
+			`id,name,data`,
+			`1,Bob,`,
+			`22,"a, ""b""",ab`,
+		)},
+		{"csv", RenderOptions{}, lines(
+			`1,Bob,`,
+			`22,"a, ""b""",ab`,
+		)},
+		{"tsv", RenderOptions{Header: true}, lines(
+			"id\tname\tdata",
+			"1\tBob\t",
+			"22\t\"a, \"\"b\"\"\"\tab",
+		)},
+		{"json", RenderOptions{Header: true}, lines(
+			`[`,
+			`{"id":1,"name":"Bob","data":null},`,
+			`{"id":22,"name":"a, \"b\"","data":"qw=="}`,
+			`]`,
+		)},
+		{"ndjson", RenderOptions{Header: true}, lines(
+			`{"id":1,"name":"Bob","data":null}`,
+			`{"id":22,"name":"a, \"b\"","data":"qw=="}`,
+		)},
+		{"markdown", RenderOptions{Header: true}, lines(
+			`| id | name | data |`,
+			`|---|---|---|`,
+			`| 1 | Bob | NULL |`,
+			`| 22 | a, "b" | ab |`,
+		)},
+		{"table", RenderOptions{Header: true}, lines(
+			`id name   data`,
+			`-- ----   ----`,
+			`1  Bob    NULL`,
+			`22 a, "b" ab`,
+		)},
+		{"table", RenderOptions{Header: true, Dividers: true}, lines(
+			`id |name   |data`,
+			`-- |----   |----`,
+			`1  |Bob    |NULL`,
+			`22 |a, "b" |ab`,
+		)},
+		{"table", RenderOptions{}, lines(
+			`1  Bob    NULL`,
+			`22 a, "b" ab`,
+		)},
+		{"column", RenderOptions{Header: true, Dividers: true}, lines(
+			`id name   data`,
+			`-- ----   ----`,
+			`1  Bob    NULL`,
+			`22 a, "b" ab`,
+		)},
+		{"box", RenderOptions{Header: true}, lines(
+			`┌────┬────────┬──────┐`,
+			`│ id │ name   │ data │`,
+			`├────┼────────┼──────┤`,
+			`│ 1  │ Bob    │ NULL │`,
+			`│ 22 │ a, "b" │ ab   │`,
+			`└────┴────────┴──────┘`,
+		)},
+		{"line", RenderOptions{Header: true}, lines(
+			`  id = 1`,
+			`name = Bob`,
+			`data = NULL`,
+			``,
+			`  id = 22`,
+			`name = a, "b"`,
+			`data = ab`,
+		)},
+	}
+	for _, test := range tests {
+		if got := render(t, test.format, test.opts); got != test.want {
+			t.Errorf("%s %+v: got:\n%s\nwant:\n%s", test.format, test.opts, got, test.want)
+		}
+	}
+}
+
+func TestRenderEmpty(t *testing.T) {
+	// an empty result is still valid json
+	var out strings.Builder
+	r, _ := NewRenderer("json", &out, RenderOptions{})
+	r.Header([]string{"id"})
+	r.Close()
+	if got := out.String(); got != "[\n]\n" {
+		t.Errorf("got %q, want %q", got, "[\n]\n")
+	}
+}
+
+func TestNewRendererInvalid(t *testing.T) {
+	if _, err := NewRenderer("xml", nil, RenderOptions{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
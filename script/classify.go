@@ -0,0 +1,154 @@
+package script
+
+import (
+	"strings"
+)
+
+// StatementInfo describes how a statement must be run
+type StatementInfo struct {
+	ReadOnly bool // does not modify the database
+	Rows     bool // returns rows, so must be run as a query
+}
+
+// Classify examines a statement to determine whether it is read-only
+// and whether it returns rows. Leading comments are ignored, common table
+// expressions are followed to the statement they introduce, pragmas are
+// distinguished by whether they set a value, and writes return rows if
+// they have a RETURNING clause. Anything unrecognized is assumed to be a write.
+func Classify(statement string) StatementInfo {
+	t := newTokenizer(statement)
+	switch t.next() {
+	case "SELECT", "VALUES", "EXPLAIN":
+		return StatementInfo{ReadOnly: true, Rows: true}
+	case "INSERT", "UPDATE", "DELETE", "REPLACE":
+		return classifyWrite(t)
+	case "WITH":
+		return classifyWith(t)
+	case "PRAGMA":
+		return classifyPragma(t)
+	}
+	return StatementInfo{}
+}
+
+// classifyWith skips the common table expressions to find the statement they precede
+func classifyWith(t *tokenizer) StatementInfo {
+	depth := 0
+	for token := t.next(); token != ""; token = t.next() {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case "SELECT", "VALUES":
+			if depth == 0 {
+				return StatementInfo{ReadOnly: true, Rows: true}
+			}
+		case "INSERT", "UPDATE", "DELETE", "REPLACE":
+			if depth == 0 {
+				return classifyWrite(t)
+			}
+		}
+	}
+	return StatementInfo{}
+}
+
+// classifyWrite looks for a RETURNING clause outside of any subquery
+func classifyWrite(t *tokenizer) StatementInfo {
+	depth := 0
+	for token := t.next(); token != ""; token = t.next() {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case "RETURNING":
+			if depth == 0 {
+				return StatementInfo{Rows: true}
+			}
+		}
+	}
+	return StatementInfo{}
+}
+
+// queryPragmas are read-only pragmas that take an argument, e.g., table_info(t)
+var queryPragmas = map[string]bool{
+	"FOREIGN_KEY_CHECK": true,
+	"FOREIGN_KEY_LIST":  true,
+	"INDEX_INFO":        true,
+	"INDEX_LIST":        true,
+	"INDEX_XINFO":       true,
+	"INTEGRITY_CHECK":   true,
+	"QUICK_CHECK":       true,
+	"TABLE_INFO":        true,
+	"TABLE_LIST":        true,
+	"TABLE_XINFO":       true,
+}
+
+// actionPragmas do something other than report or change a setting
+var actionPragmas = map[string]StatementInfo{
+	"INCREMENTAL_VACUUM": {},
+	"OPTIMIZE":           {},
+	"SHRINK_MEMORY":      {ReadOnly: true},
+	"WAL_CHECKPOINT":     {Rows: true},
+}
+
+// classifyPragma distinguishes pragmas that set a value from those that report one
+func classifyPragma(t *tokenizer) StatementInfo {
+	name := t.next()
+	token := t.next()
+	if token == "." {
+		// schema qualified, e.g., main.user_version
+		name = t.next()
+		token = t.next()
+	}
+	switch token {
+	case "=":
+		return StatementInfo{}
+	case "(":
+		if queryPragmas[name] {
+			return StatementInfo{ReadOnly: true, Rows: true}
+		}
+		if info, ok := actionPragmas[name]; ok {
+			return info
+		}
+		return StatementInfo{}
+	}
+	if info, ok := actionPragmas[name]; ok {
+		return info
+	}
+	return StatementInfo{ReadOnly: true, Rows: true}
+}
+
+// tokenizer yields the tokens of a statement that matter to Classify,
+// as read by a StatementScanner. Whitespace and comments are skipped,
+// keywords are returned in upper case, punctuation as single characters,
+// and quoted strings and identifiers as just their opening quote.
+type tokenizer struct {
+	s *StatementScanner
+}
+
+func newTokenizer(statement string) *tokenizer {
+	return &tokenizer{s: NewStatementScanner(strings.NewReader(statement))}
+}
+
+func (t *tokenizer) next() string {
+	for {
+		kind, text := t.s.token()
+		switch kind {
+		case tokenEOF:
+			return ""
+		case tokenSpace, tokenComment:
+			continue
+		case tokenWord:
+			return strings.ToUpper(text)
+		case tokenQuoted:
+			return text[:1]
+		case tokenPunct:
+			if text == ";" {
+				// the rest is another statement
+				return ""
+			}
+		}
+		return text
+	}
+}
@@ -0,0 +1,39 @@
+package script
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      StatementInfo
+	}{
+		{"select * from t", StatementInfo{ReadOnly: true, Rows: true}},
+		{"  -- leading comment\n/* and another */ SELECT 1", StatementInfo{ReadOnly: true, Rows: true}},
+		{"values(1, 2)", StatementInfo{ReadOnly: true, Rows: true}},
+		{"explain query plan select 1", StatementInfo{ReadOnly: true, Rows: true}},
+		{"insert into t values(1)", StatementInfo{}},
+		{"insert into t values(1) returning id", StatementInfo{Rows: true}},
+		{"update t set a='returning' where x=1", StatementInfo{}},
+		{`update t set "returning"=1`, StatementInfo{}},
+		{"delete from t where id in (select id from u) returning *", StatementInfo{Rows: true}},
+		{"replace into t(a) values(2) RETURNING a, b", StatementInfo{Rows: true}},
+		{"insert into t values(1); insert into t values(2) returning id", StatementInfo{}},
+		{"with x as (select 1) select * from x", StatementInfo{ReadOnly: true, Rows: true}},
+		{"with x as (select 1) delete from t where id in x", StatementInfo{}},
+		{"with x as (select 1) delete from t returning *", StatementInfo{Rows: true}},
+		{"pragma table_info(t)", StatementInfo{ReadOnly: true, Rows: true}},
+		{"pragma main.user_version", StatementInfo{ReadOnly: true, Rows: true}},
+		{"pragma user_version = 3", StatementInfo{}},
+		{"pragma main.user_version = 3", StatementInfo{}},
+		{"pragma journal_mode(wal)", StatementInfo{}},
+		{"pragma optimize", StatementInfo{}},
+		{"pragma wal_checkpoint(full)", StatementInfo{Rows: true}},
+		{"create table t (id integer)", StatementInfo{}},
+		{"", StatementInfo{}},
+	}
+	for _, test := range tests {
+		if got := Classify(test.statement); got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.statement, got, test.want)
+		}
+	}
+}
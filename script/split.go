@@ -0,0 +1,299 @@
+// Package script splits sql text into statements and classifies them,
+// for the shell, the loaders, and the web api alike.
+package script
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// StatementScanner reads sql text and returns one statement at a time.
+//
+// Unlike splitting on lines or ";", it tokenizes the input, so it knows
+// that a ";" inside a string literal, a quoted identifier, a comment,
+// a trigger body (BEGIN...END), or a CASE...END expression does not end
+// the statement. Comments are dropped from the statements returned.
+type StatementScanner struct {
+	r          io.RuneScanner
+	statement  string
+	terminated bool // the statement ended with ";"
+	line       int  // current line of input
+	start      int  // line the current statement started on
+	tokenLine  int  // line the current token started on
+	err        error
+}
+
+// NewStatementScanner returns a scanner reading from r
+func NewStatementScanner(r io.Reader) *StatementScanner {
+	rs, ok := r.(io.RuneScanner)
+	if !ok {
+		rs = bufio.NewReader(r)
+	}
+	return &StatementScanner{r: rs, line: 1}
+}
+
+// SplitStatements returns the statements in the text
+func SplitStatements(text string) ([]string, error) {
+	var statements []string
+	s := NewStatementScanner(strings.NewReader(text))
+	for s.Scan() {
+		statements = append(statements, s.Text())
+	}
+	return statements, s.Err()
+}
+
+// Complete reports whether the text ends with a complete statement,
+// in the manner of sqlite3_complete()
+func Complete(text string) bool {
+	complete := false
+	s := NewStatementScanner(strings.NewReader(text))
+	for s.Scan() {
+		complete = s.terminated
+	}
+	return complete
+}
+
+// Text returns the most recent statement, without its terminating ";"
+func (s *StatementScanner) Text() string {
+	return s.statement
+}
+
+// Line returns the line of input the most recent statement started on
+func (s *StatementScanner) Line() int {
+	return s.start
+}
+
+// Err returns the first non-EOF error encountered reading the input
+func (s *StatementScanner) Err() error {
+	return s.err
+}
+
+func (s *StatementScanner) read() (rune, error) {
+	c, _, err := s.r.ReadRune()
+	if c == '\n' {
+		s.line++
+	}
+	return c, err
+}
+
+// unread returns the rune most recently read to the input
+func (s *StatementScanner) unread(c rune) {
+	s.r.UnreadRune()
+	if c == '\n' {
+		s.line--
+	}
+}
+
+// fail records an error other than the end of input
+func (s *StatementScanner) fail(err error) {
+	if err != io.EOF && s.err == nil {
+		s.err = err
+	}
+}
+
+// peek consumes the next rune if it matches
+func (s *StatementScanner) peek(want rune) bool {
+	c, _, err := s.r.ReadRune()
+	if err != nil {
+		return false
+	}
+	if c != want {
+		s.r.UnreadRune()
+		return false
+	}
+	if c == '\n' {
+		s.line++
+	}
+	return true
+}
+
+// isWordRune reports whether c can be part of a keyword or bare identifier
+func isWordRune(c rune) bool {
+	return c == '_' || c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// Scan advances to the next statement, returning false at the end of input
+func (s *StatementScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	var buf strings.Builder
+	var words, depth int
+	var create, trigger, body bool
+	s.start = 0
+
+	// keywords are examined to track the blocks that can contain ";"
+	keyword := func(w string) {
+		words++
+		switch {
+		case words == 1:
+			create = w == "CREATE"
+		case create && words <= 4 && w == "TRIGGER":
+			trigger = true
+		case w == "CASE":
+			depth++
+		case w == "BEGIN" && trigger && !body:
+			body = true
+			depth++
+		case w == "END" && depth > 0:
+			depth--
+		}
+	}
+
+	for {
+		kind, text := s.token()
+		switch kind {
+		case tokenEOF:
+			if s.err != nil {
+				return false
+			}
+			s.statement = strings.TrimSpace(buf.String())
+			s.terminated = false
+			return s.statement != ""
+		case tokenSpace, tokenComment:
+			buf.WriteString(text)
+			continue
+		case tokenWord:
+			keyword(strings.ToUpper(text))
+		case tokenPunct:
+			if text == ";" && depth == 0 {
+				s.statement = strings.TrimSpace(buf.String())
+				if s.statement == "" {
+					// a stray ";" is an empty statement
+					continue
+				}
+				s.terminated = true
+				return true
+			}
+		}
+		if s.start == 0 {
+			s.start = s.tokenLine
+		}
+		buf.WriteString(text)
+	}
+}
+
+// token kinds returned by the scanner
+const (
+	tokenEOF     = iota
+	tokenSpace   // a run of whitespace
+	tokenComment // replaced by a newline or space
+	tokenWord    // a keyword or bare identifier
+	tokenQuoted  // a string literal or quoted identifier
+	tokenPunct   // any other single character
+)
+
+// token reads the next token of the input, returning its kind and text
+func (s *StatementScanner) token() (int, string) {
+	c, err := s.read()
+	if err != nil {
+		s.fail(err)
+		return tokenEOF, ""
+	}
+	s.tokenLine = s.line
+	var buf strings.Builder
+	buf.WriteRune(c)
+	switch {
+	case unicode.IsSpace(c):
+		s.run(&buf, unicode.IsSpace)
+		return tokenSpace, buf.String()
+	case isWordRune(c):
+		s.run(&buf, isWordRune)
+		return tokenWord, buf.String()
+	case c == '\'' || c == '"' || c == '`':
+		s.quoted(&buf, c)
+		return tokenQuoted, buf.String()
+	case c == '[':
+		s.quoted(&buf, ']')
+		return tokenQuoted, buf.String()
+	case c == '-' && s.peek('-'):
+		s.skipLine()
+		return tokenComment, "\n"
+	case c == '/' && s.peek('*'):
+		s.skipComment()
+		return tokenComment, " "
+	}
+	return tokenPunct, buf.String()
+}
+
+// run copies the runes that match
+func (s *StatementScanner) run(buf *strings.Builder, match func(rune) bool) {
+	for {
+		c, err := s.read()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if !match(c) {
+			s.unread(c)
+			return
+		}
+		buf.WriteRune(c)
+	}
+}
+
+// quoted copies a quoted string or identifier through its closing
+// character, where a doubled closing character is an escaped one
+func (s *StatementScanner) quoted(buf *strings.Builder, end rune) {
+	for {
+		c, err := s.read()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		buf.WriteRune(c)
+		if c != end {
+			continue
+		}
+		if end == ']' || !s.peek(end) {
+			return
+		}
+		buf.WriteRune(end)
+	}
+}
+
+// skipLine discards a "--" comment
+func (s *StatementScanner) skipLine() {
+	for {
+		c, err := s.read()
+		if err != nil || c == '\n' {
+			return
+		}
+	}
+}
+
+// skipComment discards a "/* */" comment
+func (s *StatementScanner) skipComment() {
+	for {
+		c, err := s.read()
+		if err != nil {
+			return
+		}
+		if c == '*' && s.peek('/') {
+			return
+		}
+	}
+}
+
+// TxControl returns BEGIN, COMMIT, or ROLLBACK if the statement
+// controls a transaction (END is a synonym for COMMIT)
+func TxControl(statement string) string {
+	fields := strings.Fields(strings.ToUpper(statement))
+	if len(fields) == 0 {
+		return ""
+	}
+	switch fields[0] {
+	case "BEGIN":
+		return "BEGIN"
+	case "COMMIT", "END":
+		return "COMMIT"
+	case "ROLLBACK":
+		// rolling back to a savepoint leaves the transaction open
+		if len(fields) == 1 || fields[1] == "TRANSACTION" && len(fields) == 2 {
+			return "ROLLBACK"
+		}
+	}
+	return ""
+}
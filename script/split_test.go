@@ -0,0 +1,70 @@
+package script
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"simple", "select 1; select 2;", []string{"select 1", "select 2"}},
+		{"unterminated", "select 1;\nselect 2", []string{"select 1", "select 2"}},
+		{"empty statements", ";; select 1;;", []string{"select 1"}},
+		{"string literal", "insert into t values('a;b', 'it''s;');", []string{"insert into t values('a;b', 'it''s;')"}},
+		{"quoted identifiers", `select "a;b", [c;d], ` + "`e;f`" + ` from t;`, []string{`select "a;b", [c;d], ` + "`e;f`" + ` from t`}},
+		{"line comment", "select 1; -- a comment; with a semicolon\nselect 2;", []string{"select 1", "select 2"}},
+		{"block comment", "select /* ; */ 1;", []string{"select   1"}},
+		{"case expression", "select case when x then 'a' else 'b' end from t; select 2;", []string{"select case when x then 'a' else 'b' end from t", "select 2"}},
+		{
+			"trigger",
+			"CREATE TRIGGER t_ins AFTER INSERT ON t BEGIN\n  insert into log values(1);\n  update c set n = n + 1;\nEND;\nselect 1;",
+			[]string{"CREATE TRIGGER t_ins AFTER INSERT ON t BEGIN\n  insert into log values(1);\n  update c set n = n + 1;\nEND", "select 1"},
+		},
+		{"transaction", "BEGIN; insert into t values(1); COMMIT;", []string{"BEGIN", "insert into t values(1)", "COMMIT"}},
+	}
+	for _, test := range tests {
+		got, err := SplitStatements(test.text)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStatementScannerLine(t *testing.T) {
+	s := NewStatementScanner(strings.NewReader("select 1;\n\n-- comment\nselect\n2;"))
+	var lines []int
+	for s.Scan() {
+		lines = append(lines, s.Line())
+	}
+	if want := []int{1, 4}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("got lines %v, want %v", lines, want)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"select 1;", true},
+		{"select 1", false},
+		{"select ';", false},
+		{"select 1; -- done", true},
+		{"CREATE TRIGGER t AFTER INSERT ON x BEGIN select 1;", false},
+		{"CREATE TRIGGER t AFTER INSERT ON x BEGIN select 1; END;", true},
+	}
+	for _, test := range tests {
+		if got := Complete(test.text); got != test.want {
+			t.Errorf("%q: got %t, want %t", test.text, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,9 @@
 #
 # convert postresql schema to sqlite
 #
+# "dqlited convert pg" is the preferred replacement, as it also converts
+# sequences and COPY data; this is kept for scripts that still call it
+#
 
 
 SELF=$0
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+const (
+	// DefaultBalanceInterval is how often the leader checks the roles of the nodes
+	DefaultBalanceInterval = 30 * time.Second
+
+	// probeTimeout is how long a node has to respond to be considered online
+	probeTimeout = 2 * time.Second
+
+	// maxDecisions is how many of the latest decisions are kept for /status/balance
+	maxDecisions = 20
+
+	// noAdjustment is a roles adjustment frequency so long that go-dqlite
+	// never adjusts roles itself, so our balancer is their only authority
+	noAdjustment = 100 * 365 * 24 * time.Hour
+)
+
+// Decision is a role change made to balance the cluster
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Node    uint64    `json:"node"`
+	Address string    `json:"address"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func (d Decision) String() string {
+	s := fmt.Sprintf("node %d (%s) %s -> %s: %s", d.Node, d.Address, d.From, d.To, d.Reason)
+	if d.Error != "" {
+		s += " (failed: " + d.Error + ")"
+	}
+	return s
+}
+
+// BalanceStatus reports the role targets and the decisions made to meet them
+type BalanceStatus struct {
+	Voters    int        `json:"voters"`
+	StandBys  int        `json:"standbys"`
+	Decisions []Decision `json:"decisions"` // made by this node while leader, latest last
+}
+
+// balancer keeps the decisions made
+type balancer struct {
+	mu        sync.Mutex
+	decisions []Decision
+}
+
+func (b *balancer) record(d Decision) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log.Println("balance:", d)
+	b.decisions = append(b.decisions, d)
+	if len(b.decisions) > maxDecisions {
+		b.decisions = b.decisions[len(b.decisions)-maxDecisions:]
+	}
+}
+
+func (b *balancer) list() []Decision {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Decision{}, b.decisions...)
+}
+
+// balancePlan returns the role changes that bring the online nodes to the
+// target numbers of voters and standbys. At most one node changes role for
+// each target per round, so the cluster is re-examined between changes.
+//
+// Beyond the targets themselves, the policy is:
+//   - only online nodes are promoted or demoted
+//   - standbys are promoted to voter before spares, as they have the data
+//   - the leader is never demoted
+//   - drained nodes are never promoted
+func balancePlan(nodes []client.NodeInfo, online, drained map[uint64]bool, leaderID uint64, voters, standbys int) []Decision {
+	nodes = append([]client.NodeInfo{}, nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	count := func(role client.NodeRole) int {
+		n := 0
+		for _, node := range nodes {
+			if node.Role == role && online[node.ID] {
+				n++
+			}
+		}
+		return n
+	}
+	// find returns the first online node with one of the roles, in order of preference
+	find := func(promote bool, roles ...client.NodeRole) int {
+		for _, role := range roles {
+			for i, node := range nodes {
+				if node.Role != role || !online[node.ID] {
+					continue
+				}
+				if promote && drained[node.ID] || !promote && node.ID == leaderID {
+					continue
+				}
+				return i
+			}
+		}
+		return -1
+	}
+	var plan []Decision
+	change := func(i int, to client.NodeRole, reason string) {
+		plan = append(plan, Decision{
+			Node:    nodes[i].ID,
+			Address: nodes[i].Address,
+			From:    nodes[i].Role.String(),
+			To:      to.String(),
+			Reason:  reason,
+		})
+		nodes[i].Role = to
+	}
+
+	if n := count(client.Voter); n < voters {
+		if i := find(true, client.StandBy, client.Spare); i >= 0 {
+			change(i, client.Voter, fmt.Sprintf("%d of %d voters online", n, voters))
+		}
+	} else if n > voters {
+		to := client.Spare
+		if count(client.StandBy) < standbys {
+			to = client.StandBy
+		}
+		if i := find(false, client.Voter); i >= 0 {
+			change(i, to, fmt.Sprintf("%d voters online, want %d", n, voters))
+		}
+	}
+
+	if n := count(client.StandBy); n < standbys {
+		if i := find(true, client.Spare); i >= 0 {
+			change(i, client.StandBy, fmt.Sprintf("%d of %d standbys online", n, standbys))
+		}
+	} else if n > standbys {
+		if i := find(false, client.StandBy); i >= 0 {
+			change(i, client.Spare, fmt.Sprintf("%d standbys online, want %d", n, standbys))
+		}
+	}
+	return plan
+}
+
+// balance periodically adjusts the roles of the nodes while this node is leader
+func (s *Server) balance(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.BalanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.balanceOnce(ctx); err != nil {
+			log.Println("balance:", err)
+		}
+	}
+}
+
+// balanceOnce applies one round of the balance plan, if this node is leader
+func (s *Server) balanceOnce(ctx context.Context) error {
+	leader, err := s.dq.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	defer leader.Close()
+	info, err := leader.Leader(ctx)
+	if err != nil || info == nil || info.ID != s.dq.ID() {
+		return err
+	}
+	nodes, err := leader.Cluster(ctx)
+	if err != nil {
+		return err
+	}
+	online := make(map[uint64]bool, len(nodes))
+	for _, node := range nodes {
+		online[node.ID] = node.ID == s.dq.ID() || s.probe(ctx, node.Address)
+	}
+	drained, err := s.drainedNodes(ctx)
+	if err != nil {
+		// better to balance without knowing than not at all
+		log.Println("balance: can't read drained nodes:", err)
+	}
+	for _, d := range balancePlan(nodes, online, drained, info.ID, s.cfg.Voters, s.cfg.StandBys) {
+		role, _ := ParseRole(d.To)
+		if err := leader.Assign(ctx, d.Node, role); err != nil {
+			d.Error = err.Error()
+		}
+		d.Time = time.Now()
+		s.balancer.record(d)
+	}
+	return nil
+}
+
+// probe reports whether the node at the address responds
+func (s *Server) probe(ctx context.Context, address string) bool {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	cli, err := client.New(ctx, address, client.WithDialFunc(s.dial), client.WithLogFunc(s.cfg.LogFunc))
+	if err != nil {
+		return false
+	}
+	cli.Close()
+	return true
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+func TestBalancePlan(t *testing.T) {
+	const (
+		V = client.Voter
+		S = client.StandBy
+		P = client.Spare
+	)
+	// nodes are numbered from 1 in the order of their roles
+	cluster := func(roles ...client.NodeRole) []client.NodeInfo {
+		nodes := make([]client.NodeInfo, len(roles))
+		for i, role := range roles {
+			nodes[i] = client.NodeInfo{ID: uint64(i + 1), Role: role}
+		}
+		return nodes
+	}
+	ids := func(ids ...uint64) map[uint64]bool {
+		m := make(map[uint64]bool)
+		for _, id := range ids {
+			m[id] = true
+		}
+		return m
+	}
+	type change struct {
+		node     uint64
+		from, to client.NodeRole
+	}
+	tests := []struct {
+		name     string
+		nodes    []client.NodeInfo
+		offline  map[uint64]bool
+		drained  map[uint64]bool
+		leader   uint64
+		voters   int
+		standbys int
+		want     []change
+	}{
+		{
+			name:     "balanced",
+			nodes:    cluster(V, V, V, S, P),
+			leader:   1,
+			voters:   3,
+			standbys: 1,
+		},
+		{
+			name:   "standby promoted before spare",
+			nodes:  cluster(V, V, P, S),
+			leader: 1,
+			voters: 3,
+			want:   []change{{4, S, V}},
+		},
+		{
+			name:    "offline voter replaced",
+			nodes:   cluster(V, V, V, P),
+			offline: ids(2),
+			leader:  1,
+			voters:  3,
+			want:    []change{{4, P, V}},
+		},
+		{
+			name:    "offline nodes are not promoted",
+			nodes:   cluster(V, V, S, P),
+			offline: ids(3),
+			leader:  1,
+			voters:  3,
+			want:    []change{{4, P, V}},
+		},
+		{
+			name:     "drained nodes are not promoted",
+			nodes:    cluster(V, V, S, S),
+			drained:  ids(3),
+			leader:   1,
+			voters:   3,
+			standbys: 1,
+			want:     []change{{4, S, V}},
+		},
+		{
+			name:     "drained standby stays a standby",
+			nodes:    cluster(V, V, S),
+			drained:  ids(3),
+			leader:   1,
+			voters:   3,
+			standbys: 1,
+		},
+		{
+			name:     "spare promoted before drained standby",
+			nodes:    cluster(V, V, S, P),
+			drained:  ids(3),
+			leader:   1,
+			voters:   3,
+			standbys: 1,
+			want:     []change{{4, P, V}},
+		},
+		{
+			name:     "leader is not demoted",
+			nodes:    cluster(V, V, V, V),
+			leader:   1,
+			voters:   3,
+			standbys: 1,
+			want:     []change{{2, V, S}},
+		},
+		{
+			name:     "extra voter becomes spare when standbys are met",
+			nodes:    cluster(V, V, V, V, S),
+			leader:   2,
+			voters:   3,
+			standbys: 1,
+			want:     []change{{1, V, P}},
+		},
+		{
+			name:     "one change per target",
+			nodes:    cluster(V, P, P, P, P),
+			leader:   1,
+			voters:   3,
+			standbys: 2,
+			want:     []change{{2, P, V}, {3, P, S}},
+		},
+		{
+			name:     "extra standby becomes spare",
+			nodes:    cluster(V, S, S),
+			leader:   1,
+			voters:   1,
+			standbys: 1,
+			want:     []change{{2, S, P}},
+		},
+	}
+	for _, test := range tests {
+		online := make(map[uint64]bool)
+		for _, node := range test.nodes {
+			online[node.ID] = !test.offline[node.ID]
+		}
+		plan := balancePlan(test.nodes, online, test.drained, test.leader, test.voters, test.standbys)
+		var got []change
+		for _, d := range plan {
+			from, _ := ParseRole(d.From)
+			to, _ := ParseRole(d.To)
+			got = append(got, change{d.Node, from, to})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
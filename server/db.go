@@ -0,0 +1,367 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/app"
+	"github.com/paulstuart/dqlited/script"
+	"github.com/pkg/errors"
+)
+
+// Result is the results of a database execution
+// used by the web api for the python DBI adapter
+type Result struct {
+	LastInsertID int64   `json:"last_insert_id,omitempty"`
+	RowsAffected int64   `json:"rows_affected,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	Time         float64 `json:"time,omitempty"`
+	Rows         *Rows   `json:"rows,omitempty"` // returned by a RETURNING clause
+}
+
+// Rows represents the outcome of an operation that returns query data.
+// used by the web api for the python DBI adapter
+type Rows struct {
+	Columns []string        `json:"columns,omitempty"`
+	Types   []string        `json:"types,omitempty"`
+	Values  [][]interface{} `json:"values,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Time    float64         `json:"time,omitempty"`
+}
+
+// ExecuteResponse is the response used by pydqlite
+type ExecuteResponse struct {
+	Results []Result `json:"results,omitempty"`
+	Time    float64  `json:"time,omitempty"`
+}
+
+// Response represents a response from the HTTP service.
+type Response struct {
+	Results interface{} `json:"results,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Time    float64     `json:"time,omitempty"`
+}
+
+// DBHandlers returns the web api for the databases of the node:
+// /db/execute/<name>, /db/query/<name>, and /db/load/<name>
+func DBHandlers(ctx context.Context, dq *app.App) []Route {
+	return []Route{
+		{"/db/execute/", makeHandleExec(ctx, dq), true},
+		{"/db/query/", makeHandleQuery(ctx, dq), false},
+		{"/db/load/", makeHandleLoad(ctx, dq), true},
+	}
+}
+
+// ScanRows collects the result set of the query
+func ScanRows(rows *sql.Rows) (Rows, error) {
+	var resp Rows
+	resp.Columns, _ = rows.Columns()
+	resp.Types = make([]string, len(resp.Columns))
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return resp, errors.Wrap(err, "column types fail")
+	}
+	for i, colType := range colTypes {
+		resp.Types[i] = colType.DatabaseTypeName()
+	}
+	// TODO: add support for NextResultSet()
+	for rows.Next() {
+		buffer := make([]interface{}, len(resp.Columns))
+		scanTo := make([]interface{}, len(buffer))
+		for i := range buffer {
+			scanTo[i] = &buffer[i]
+		}
+		if err := rows.Scan(scanTo...); err != nil {
+			return resp, errors.Wrap(err, "failed to scan row")
+		}
+		resp.Values = append(resp.Values, buffer)
+	}
+	return resp, rows.Err()
+}
+
+// Execer runs statements, e.g., a *sql.DB or a *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ExecuteStatement runs a statement, collecting any rows it returns
+// (e.g., from INSERT ... RETURNING) rather than discarding them
+func ExecuteStatement(ctx context.Context, db Execer, statement string) (Result, error) {
+	if info := script.Classify(statement); info.Rows && !info.ReadOnly {
+		rows, err := db.QueryContext(ctx, statement)
+		if err != nil {
+			return Result{}, err
+		}
+		defer rows.Close()
+		returned, err := ScanRows(rows)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{RowsAffected: int64(len(returned.Values)), Rows: &returned}, nil
+	}
+	resp, err := db.ExecContext(ctx, statement)
+	if err != nil {
+		return Result{}, err
+	}
+	lastID, _ := resp.LastInsertId()
+	affected, _ := resp.RowsAffected()
+	return Result{LastInsertID: lastID, RowsAffected: affected}, nil
+}
+
+// Execute runs the statements in a single transaction, returning a result
+// for each, so any rows returned are matched with their statement
+func Execute(ctx context.Context, db *sql.DB, statements ...string) (*ExecuteResponse, error) {
+	started := time.Now()
+	results := make([]Result, 0, len(statements))
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create transaction")
+	}
+	for i, statement := range statements {
+		result, err := ExecuteStatement(ctx, tx, statement)
+		if err != nil {
+			tx.Rollback()
+			log.Printf("EXEC FAIL FOR: %q -- %v\n", statement, err)
+			return nil, errors.Wrapf(err, "execute fail (%d/%d): %q", i+1, len(statements), statement)
+		}
+		results = append(results, result)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "could not commit transaction")
+	}
+
+	delta := time.Now().Sub(started).Seconds()
+	return &ExecuteResponse{Results: results, Time: delta}, nil
+}
+
+// QueryRows returns the rows of a query
+func QueryRows(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Rows, error) {
+	log.Printf("QUERY: %s ARGS: %v\n", query, args)
+	reply := make([]Rows, 0, 32)
+	if info := script.Classify(query); !info.ReadOnly || !info.Rows {
+		return nil, fmt.Errorf("invalid query: %q -- must be a read-only statement that returns rows", query)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "query failed")
+	}
+	defer rows.Close()
+	resp, err := ScanRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	reply = append(reply, resp)
+	return reply, nil
+}
+
+// Load applies the statements read from r, e.g., a sqlite3 dump.
+// An explicit BEGIN ... COMMIT in the input is applied as an actual
+// transaction, and any rows returned are discarded.
+func Load(ctx context.Context, db *sql.DB, r io.Reader) error {
+	scanner := script.NewStatementScanner(r)
+	var err error
+	var tx *sql.Tx
+	defer func() {
+		// only an error leaves the transaction open
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	for scanner.Scan() {
+		stmt := scanner.Text()
+		switch script.TxControl(stmt) {
+		case "BEGIN":
+			if tx != nil {
+				return fmt.Errorf("line %d: transaction already started", scanner.Line())
+			}
+			tx, err = db.BeginTx(ctx, nil)
+			if err != nil {
+				return errors.Wrap(err, "could not create transaction")
+			}
+			continue
+		case "COMMIT":
+			if tx == nil {
+				return fmt.Errorf("line %d: commit without a transaction", scanner.Line())
+			}
+			err, tx = tx.Commit(), nil
+			if err != nil {
+				return errors.Wrapf(err, "line %d: could not commit transaction", scanner.Line())
+			}
+			continue
+		case "ROLLBACK":
+			if tx == nil {
+				return fmt.Errorf("line %d: rollback without a transaction", scanner.Line())
+			}
+			err, tx = tx.Rollback(), nil
+			if err != nil {
+				return errors.Wrap(err, "could not roll back transaction")
+			}
+			continue
+		}
+		// within a transaction everything must go through it, as the
+		// connection is held by the transaction
+		var rows *sql.Rows
+		switch query := script.Classify(stmt).Rows; {
+		case tx != nil && query:
+			rows, err = tx.QueryContext(ctx, stmt)
+		case tx != nil:
+			_, err = tx.ExecContext(ctx, stmt)
+		case query:
+			rows, err = db.QueryContext(ctx, stmt)
+		default:
+			_, err = db.ExecContext(ctx, stmt)
+		}
+		if rows != nil {
+			err = rows.Close()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "line %d: %s", scanner.Line(), stmt)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if tx != nil {
+		// the input ended without closing its transaction, so complete it
+		// rather than silently discarding what was applied
+		err, tx = tx.Commit(), nil
+		return errors.Wrap(err, "could not commit transaction left open at end of input")
+	}
+	return nil
+}
+
+// dbName returns the database named by the last element of the request path
+func dbName(r *http.Request) string {
+	name := r.URL.Path
+	if i := strings.LastIndex(name, "/"); i > 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, j *ExecuteResponse) {
+	enc := json.NewEncoder(w)
+	if pretty, _ := isPretty(r); pretty {
+		enc.SetIndent("", "    ")
+	}
+
+	if err := enc.Encode(j); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func makeHandleExec(ctx context.Context, dq *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		dbname := dbName(r)
+		db, err := dq.Open(r.Context(), dbname)
+		if err != nil {
+			log.Printf("error opening db: %q -- %v\n", dbname, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer db.Close()
+
+		defer r.Body.Close()
+		var statements []string
+		if err := json.NewDecoder(r.Body).Decode(&statements); err != nil {
+			log.Printf("exec error getting queries: %v\n", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := Execute(r.Context(), db, statements...)
+		if err != nil {
+			log.Printf("error executing queries: %v\n", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, r, resp)
+	}
+}
+
+// makeHandleLoad applies a posted sql script (e.g., a sqlite3 dump) to the database
+func makeHandleLoad(ctx context.Context, dq *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		dbname := dbName(r)
+		db, err := dq.Open(r.Context(), dbname)
+		if err != nil {
+			log.Printf("error opening db: %q -- %v\n", dbname, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer db.Close()
+
+		defer r.Body.Close()
+		started := time.Now()
+		if err := Load(r.Context(), db, r.Body); err != nil {
+			log.Printf("error loading db: %q -- %v\n", dbname, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply := Response{
+			Time: time.Now().Sub(started).Seconds(),
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(reply)
+	}
+}
+
+func makeHandleQuery(ctx context.Context, dq *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			log.Printf("invalid method: %q\n", r.Method)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		dbname := dbName(r)
+		db, err := dq.Open(r.Context(), dbname)
+		if err != nil {
+			log.Printf("error opening db: %q -- %v\n", dbname, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer db.Close()
+
+		queries, err := requestQueries(r)
+		if err != nil {
+			log.Printf("error getting queries: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for i, query := range queries {
+			resp, err := QueryRows(r.Context(), db, query)
+			if err != nil {
+				log.Printf("error executing queries (%d/%d): %q %v\n", i+1, len(queries), query, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			reply := Response{
+				Results: resp,
+			}
+			enc := json.NewEncoder(w)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			enc.Encode(reply)
+		}
+	}
+}
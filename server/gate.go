@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// gate admits requests that write to the database until it is closed,
+// after which they are refused while those already admitted finish
+type gate struct {
+	mu     sync.Mutex
+	closed bool
+	active int
+	idle   chan struct{} // closed once the gate is closed and there are no active requests
+}
+
+// enter admits a request, unless the gate is closed
+func (g *gate) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// leave marks an admitted request as finished
+func (g *gate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.closed && g.active == 0 {
+		close(g.idle)
+	}
+}
+
+// close refuses further requests
+func (g *gate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return
+	}
+	g.closed = true
+	g.idle = make(chan struct{})
+	if g.active == 0 {
+		close(g.idle)
+	}
+}
+
+// open admits requests again
+func (g *gate) open() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = false
+	g.idle = nil
+}
+
+// wait returns once the admitted requests have finished, or the context is done
+func (g *gate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	idle := g.idle
+	g.mu.Unlock()
+	if idle == nil {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handler wraps a handler that writes, refusing requests while the gate is closed
+func (g *gate) handler(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !g.enter() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "node is not accepting writes", http.StatusServiceUnavailable)
+			return
+		}
+		defer g.leave()
+		fn(w, r)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGateWait(t *testing.T) {
+	var g gate
+	// an open gate has nothing to wait for
+	if err := g.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !g.enter() {
+		t.Fatal("open gate refused a request")
+	}
+	g.close()
+	if g.enter() {
+		t.Fatal("closed gate admitted a request")
+	}
+
+	// the admitted request is still active
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	done := make(chan error)
+	go func() { done <- g.wait(context.Background()) }()
+	g.leave()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return once the request left")
+	}
+
+	// closing again is harmless
+	g.close()
+	if err := g.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGateHandler(t *testing.T) {
+	var g gate
+	called := 0
+	handler := g.handler(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	})
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/db/execute/test", nil))
+		return w
+	}
+	if w := serve(); w.Code != http.StatusOK || called != 1 {
+		t.Errorf("open gate: got status %d and %d calls, want 200 and 1", w.Code, called)
+	}
+	g.close()
+	w := serve()
+	if w.Code != http.StatusServiceUnavailable || called != 1 {
+		t.Errorf("closed gate: got status %d and %d calls, want 503 and 1", w.Code, called)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("closed gate: missing Retry-After")
+	}
+	// requests that were served have left
+	if err := g.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGateOpen(t *testing.T) {
+	var g gate
+	g.enter()
+	g.close()
+	g.open()
+	if !g.enter() {
+		t.Fatal("reopened gate refused a request")
+	}
+	// nothing to wait for while open, even with requests active
+	if err := g.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	g.leave()
+	g.leave()
+
+	// closing again waits for the requests admitted since
+	g.enter()
+	g.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	g.leave()
+	if err := g.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/pkg/errors"
+)
+
+// SystemDatabase holds the state that dqlited shares across the cluster
+const SystemDatabase = "dqlited"
+
+// undoTimeout is how long undoing a failed drain may take
+const undoTimeout = 5 * time.Second
+
+// Maintenance describes whether the node is drained for maintenance
+type Maintenance struct {
+	Drained bool      `json:"drained"`
+	Since   time.Time `json:"since,omitempty"`
+	Demoted bool      `json:"demoted,omitempty"` // from voter to standby, until undrained
+}
+
+// Maintenance returns the maintenance state of the node
+func (s *Server) Maintenance() Maintenance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maint
+}
+
+// Drain puts the node into maintenance: it refuses further writes, waits for
+// those in progress, transfers leadership away if it holds it and, if demote
+// is set, steps down from voter to standby. The node remains a member of the
+// cluster, replicating data, until it is undrained. If a step fails the node
+// is left as it was, so Drain can simply be retried.
+func (s *Server) Drain(ctx context.Context, demote bool) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	m := s.Maintenance()
+	if !m.Drained {
+		log.Println("draining node for maintenance")
+		m = Maintenance{Drained: true, Since: time.Now()}
+		if err := s.drain(ctx, m); err != nil {
+			s.undoDrain()
+			return err
+		}
+		s.setMaintenance(m)
+	}
+	if !demote || m.Demoted {
+		return nil
+	}
+	return s.demote(ctx, m)
+}
+
+// drain refuses further writes, records the node as drained so the leader
+// won't promote it, waits for the writes in progress, and hands over leadership
+func (s *Server) drain(ctx context.Context, m Maintenance) error {
+	s.writes.close()
+	if err := s.markDrained(ctx, m); err != nil {
+		return err
+	}
+	dctx, cancel := context.WithTimeout(ctx, s.cfg.DrainTimeout)
+	err := s.writes.wait(dctx)
+	cancel()
+	if err != nil {
+		log.Println("writes still in progress after drain timeout:", err)
+	}
+	return s.transferLeadership(ctx)
+}
+
+// undoDrain reopens the node after a failed drain. The context of the drain
+// may be what failed, so the record is cleared with a context of its own.
+func (s *Server) undoDrain() {
+	ctx, cancel := context.WithTimeout(context.Background(), undoTimeout)
+	defer cancel()
+	if err := s.clearDrained(ctx); err != nil {
+		log.Println("can't clear maintenance after failed drain:", err)
+	}
+	s.writes.open()
+}
+
+// demote steps a drained voter down to standby
+func (s *Server) demote(ctx context.Context, m Maintenance) error {
+	role, err := s.role(ctx)
+	if err != nil || role != client.Voter {
+		return err
+	}
+	if err := s.assign(ctx, client.StandBy); err != nil {
+		return err
+	}
+	m.Demoted = true
+	if err := s.markDrained(ctx, m); err != nil {
+		// unrecorded, the voting role would not be restored on undrain
+		if err := s.assign(ctx, client.Voter); err != nil {
+			log.Println("can't restore voter role after failed demote:", err)
+		}
+		return err
+	}
+	s.setMaintenance(m)
+	return nil
+}
+
+// Undrain takes the node out of maintenance, restoring its voting role if
+// it was demoted, and accepts writes again. The maintenance record is
+// cleared whether or not the node thinks it is drained.
+func (s *Server) Undrain(ctx context.Context) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	m := s.Maintenance()
+	log.Println("undraining node after maintenance")
+	if err := s.clearDrained(ctx); err != nil {
+		return err
+	}
+	if m.Demoted {
+		// the balancer may have promoted the node once its record was cleared
+		role, err := s.role(ctx)
+		if err != nil {
+			return err
+		}
+		if role != client.Voter {
+			if err := s.assign(ctx, client.Voter); err != nil {
+				return err
+			}
+		}
+	}
+	s.setMaintenance(Maintenance{})
+	s.writes.open()
+	return nil
+}
+
+func (s *Server) setMaintenance(m Maintenance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maint = m
+}
+
+// loadMaintenance restores the maintenance state recorded for the node,
+// so a node restarted while drained stays drained
+func (s *Server) loadMaintenance(ctx context.Context) error {
+	db, err := s.systemDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m := Maintenance{Drained: true}
+	err = db.QueryRowContext(ctx, "select since, demoted from maintenance where id=?", s.dq.ID()).Scan(&m.Since, &m.Demoted)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "can't read maintenance")
+	}
+	log.Println("node is drained for maintenance since:", m.Since)
+	s.writes.close()
+	s.setMaintenance(m)
+	return nil
+}
+
+// systemDB opens the system database, creating its tables as needed
+func (s *Server) systemDB(ctx context.Context) (*sql.DB, error) {
+	db, err := s.dq.Open(ctx, SystemDatabase)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open system database")
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS maintenance (
+    id      INTEGER PRIMARY KEY,
+    since   DATETIME NOT NULL,
+    demoted BOOLEAN NOT NULL DEFAULT 0
+)`)
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "can't create maintenance table")
+	}
+	return db, nil
+}
+
+// markDrained records the maintenance state of the node for the cluster
+func (s *Server) markDrained(ctx context.Context, m Maintenance) error {
+	db, err := s.systemDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, "insert or replace into maintenance (id, since, demoted) values(?,?,?)",
+		s.dq.ID(), m.Since, m.Demoted)
+	return errors.Wrap(err, "can't record maintenance")
+}
+
+// clearDrained removes the node's maintenance record
+func (s *Server) clearDrained(ctx context.Context) error {
+	db, err := s.systemDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, "delete from maintenance where id=?", s.dq.ID())
+	return errors.Wrap(err, "can't clear maintenance")
+}
+
+// drainedNodes returns the ids of the nodes in maintenance
+func (s *Server) drainedNodes(ctx context.Context) (map[uint64]bool, error) {
+	db, err := s.systemDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, "select id from maintenance")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	drained := make(map[uint64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		drained[uint64(id)] = true
+	}
+	return drained, rows.Err()
+}
+
+// transferLeadership moves leadership to another voter if this node is the leader
+func (s *Server) transferLeadership(ctx context.Context) error {
+	leader, err := s.dq.Leader(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't connect to cluster leader")
+	}
+	defer leader.Close()
+	info, err := leader.Leader(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't get leader info")
+	}
+	if info == nil || info.ID != s.dq.ID() {
+		return nil
+	}
+	nodes, err := leader.Cluster(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't get cluster")
+	}
+	for _, node := range nodes {
+		if node.ID == s.dq.ID() || node.Role != client.Voter {
+			continue
+		}
+		// the voter may be offline, so try the next one if it fails
+		if err = leader.Transfer(ctx, node.ID); err == nil {
+			log.Printf("transferred leadership to node: %d\n", node.ID)
+			return nil
+		}
+		log.Printf("can't transfer leadership to node %d: %v\n", node.ID, err)
+	}
+	return fmt.Errorf("no voter could take over leadership from node: %d", s.dq.ID())
+}
+
+// role returns the current role of the node
+func (s *Server) role(ctx context.Context) (client.NodeRole, error) {
+	leader, err := s.dq.Leader(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't connect to cluster leader")
+	}
+	defer leader.Close()
+	nodes, err := leader.Cluster(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't get cluster")
+	}
+	for _, node := range nodes {
+		if node.ID == s.dq.ID() {
+			return node.Role, nil
+		}
+	}
+	return 0, fmt.Errorf("node %d is not a cluster member", s.dq.ID())
+}
+
+// assign gives the node a new role
+func (s *Server) assign(ctx context.Context, role client.NodeRole) error {
+	leader, err := s.dq.Leader(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't connect to cluster leader")
+	}
+	defer leader.Close()
+	log.Printf("assigning node %d role: %s\n", s.dq.ID(), role)
+	return errors.Wrapf(leader.Assign(ctx, s.dq.ID(), role), "can't assign role: %s", role)
+}
+
+// handleMaintenance drains the node on POST (with ?demote=true to step down
+// from voter), undrains it on DELETE, and reports its state either way
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		demote, _ := strconv.ParseBool(r.URL.Query().Get("demote"))
+		err = s.Drain(r.Context(), demote)
+	case http.MethodDelete:
+		err = s.Undrain(r.Context())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Maintenance())
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// return the db queries submitted with the request
+func requestQueries(r *http.Request) ([]string, error) {
+	if r.Method == "GET" {
+		query, err := stmtParam(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get statement parameters")
+		}
+		if query == "" {
+			return nil, errors.New("no query given")
+		}
+		return []string{query}, nil
+	}
+
+	defer r.Body.Close()
+
+	qs := []string{}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading request body")
+	}
+	if err := json.Unmarshal(b, &qs); err != nil {
+		return nil, errors.Wrap(err, "failed unmarshalling request")
+	}
+	if len(qs) == 0 {
+		return nil, errors.New("empty request")
+	}
+
+	return qs, nil
+}
+
+// queryParam returns whether the given query param is set to true.
+func queryParam(req *http.Request, param string) (bool, error) {
+	err := req.ParseForm()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := req.Form[param]; ok {
+		return true, nil
+	}
+	return false, nil
+}
+
+// durationParam returns the duration of the given query param, if set.
+func durationParam(req *http.Request, param string) (time.Duration, bool, error) {
+	q := req.URL.Query()
+	t := strings.TrimSpace(q.Get(param))
+	if t == "" {
+		return 0, false, nil
+	}
+	dur, err := time.ParseDuration(t)
+	if err != nil {
+		return 0, false, err
+	}
+	return dur, true, nil
+}
+
+// stmtParam returns the value for URL param 'q', if present.
+func stmtParam(req *http.Request) (string, error) {
+	q := req.URL.Query()
+	return strings.TrimSpace(q.Get("q")), nil
+}
+
+// fmtParam returns the value for URL param 'fmt', if present.
+func fmtParam(req *http.Request) (string, error) {
+	q := req.URL.Query()
+	return strings.TrimSpace(q.Get("fmt")), nil
+}
+
+// isPretty returns whether the HTTP response body should be pretty-printed.
+func isPretty(req *http.Request) (bool, error) {
+	return queryParam(req, "pretty")
+}
+
+// isAtomic returns whether the HTTP request is an atomic request.
+// TODO: apply or remove this functionality (used by pydqlite)
+func isAtomic(req *http.Request) (bool, error) {
+	// "transaction" is checked for backwards compatibility with
+	// client libraries.
+	for _, q := range []string{"atomic", "transaction"} {
+		if a, err := queryParam(req, q); err != nil || a {
+			return a, err
+		}
+	}
+	return false, nil
+}
+
+// noLeader returns whether processing should skip the leader check.
+func noLeader(req *http.Request) (bool, error) {
+	return queryParam(req, "noleader")
+}
+
+// timings returns whether timings are requested.
+func timings(req *http.Request) (bool, error) {
+	return queryParam(req, "timings")
+}
+
+// txTimeout returns the duration of any transaction timeout set.
+func txTimeout(req *http.Request) (time.Duration, bool, error) {
+	return durationParam(req, "tx_timeout")
+}
+
+// idleTimeout returns the duration of any idle connection timeout set.
+func idleTimeout(req *http.Request) (time.Duration, bool, error) {
+	return durationParam(req, "idle_timeout")
+}
@@ -0,0 +1,450 @@
+// Package server runs a dqlite node along with its web api,
+// so that it can be embedded within another Go service.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/app"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultAddress is the dqlite address of a node if none is given
+	DefaultAddress = "127.0.0.1:9181"
+
+	// DefaultDir is the data directory of a node if none is given
+	DefaultDir = "/tmp/dqlited"
+
+	// DefaultDrainTimeout is how long shutdown waits for writes in progress
+	DefaultDrainTimeout = 30 * time.Second
+)
+
+// Route maps a path to its http handler
+type Route struct {
+	Path  string
+	Func  http.HandlerFunc
+	Write bool // refused once the node starts shutting down
+}
+
+// ServerConfig describes a dqlite node and its web api
+type ServerConfig struct {
+	ID       int      // node number, for logging
+	Dir      string   // data directory
+	Address  string   // dqlite address of the node
+	Cluster  []string // addresses of existing nodes to join
+	Skip     bool     // don't join the cluster
+	Role     string   // voter, standby or spare (default is chosen by the cluster)
+	Web      string   // address of the web api (blank to only serve through Handler)
+	Cert     string   // TLS certificate and key files, for dqlite connections
+	Key      string
+	LogFunc  client.LogFunc
+	Handlers func(ctx context.Context, dq *app.App) []Route // e.g., DBHandlers
+
+	DrainTimeout    time.Duration // how long shutdown waits for writes in progress
+	LeaveOnShutdown bool          // remove the node from the cluster when it stops
+
+	// when Voters is set, the leader balances the roles of the nodes to
+	// keep that many voters (and StandBys standbys) online
+	Voters          int
+	StandBys        int
+	BalanceInterval time.Duration
+
+	// Ready is called once the node has joined the cluster and has its role,
+	// before the web api is served, e.g., to create databases
+	Ready func(ctx context.Context, dq *app.App) error
+}
+
+// Validate checks the config, filling in the defaults
+func (cfg *ServerConfig) Validate() error {
+	if cfg.Dir == "" {
+		cfg.Dir = DefaultDir
+	}
+	if cfg.Address == "" {
+		cfg.Address = DefaultAddress
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return errors.Wrapf(err, "invalid address: %q", cfg.Address)
+	}
+	if cfg.Web != "" {
+		if _, _, err := net.SplitHostPort(cfg.Web); err != nil {
+			return errors.Wrapf(err, "invalid web address: %q", cfg.Web)
+		}
+	}
+	if cfg.Role != "" {
+		if _, err := ParseRole(cfg.Role); err != nil {
+			return err
+		}
+	}
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return fmt.Errorf("both a certificate and a key are required for TLS")
+	}
+	if cfg.LogFunc == nil {
+		cfg.LogFunc = client.DefaultLogFunc
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = DefaultDrainTimeout
+	}
+	if cfg.Voters < 0 || cfg.StandBys < 0 {
+		return fmt.Errorf("voters and standbys can't be negative")
+	}
+	if cfg.Voters == 0 && cfg.StandBys > 0 {
+		return fmt.Errorf("standbys are only balanced along with voters")
+	}
+	if cfg.BalanceInterval <= 0 {
+		cfg.BalanceInterval = DefaultBalanceInterval
+	}
+	return nil
+}
+
+// ParseRole returns the node role of the given name
+func ParseRole(s string) (client.NodeRole, error) {
+	switch strings.ToLower(s) {
+	case "voter":
+		return client.Voter, nil
+	case "standby", "stand-by":
+		return client.StandBy, nil
+	case "spare":
+		return client.Spare, nil
+	}
+	return client.NodeRole(255), fmt.Errorf("invalid role name: %q", s)
+}
+
+// Server is a dqlite node with a web api
+type Server struct {
+	cfg      ServerConfig
+	dq       *app.App
+	mux      *http.ServeMux
+	web      *http.Server
+	listener net.Listener
+	writes   gate
+	cancel   context.CancelFunc // of the context given to the handlers
+	done     chan struct{}      // closed when a shutdown is requested
+	once     sync.Once
+	dial     client.DialFunc
+	balancer balancer
+	mu       sync.Mutex // guards maint
+	maint    Maintenance
+	ops      sync.Mutex // serializes Drain and Undrain
+}
+
+// New returns a server for the config, which is validated
+func New(cfg ServerConfig) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Server{cfg: cfg, mux: http.NewServeMux(), done: make(chan struct{}), dial: client.DefaultDialFunc}, nil
+}
+
+// Start starts the node, waiting for it to join the cluster, then serves the web api
+func (s *Server) Start(ctx context.Context) error {
+	cfg := s.cfg
+	log.Printf("starting server node:%d address:%q dir:%q cluster:%v\n", cfg.ID, cfg.Address, cfg.Dir, cfg.Cluster)
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return errors.Wrapf(err, "can't create %s", cfg.Dir)
+	}
+
+	options := []app.Option{app.WithAddress(cfg.Address), app.WithLogFunc(cfg.LogFunc)}
+	if cfg.Skip {
+		// a new node becomes the first of its own cluster, an existing one rejoins its own
+		log.Println("not joining cluster:", cfg.Cluster)
+	} else {
+		options = append(options, app.WithCluster(cfg.Cluster))
+	}
+	if cfg.Cert != "" {
+		listen, dial, err := tlsConfig(cfg.Cert, cfg.Key)
+		if err != nil {
+			return err
+		}
+		options = append(options, app.WithTLS(listen, dial))
+		s.dial = client.DialFuncWithTLS(client.DefaultDialFunc, dial)
+	}
+	if cfg.Voters > 0 {
+		// go-dqlite would otherwise promote a drained standby whenever it
+		// is short of voters, undoing the drain. Its targets still apply
+		// to the role a node assumes when it joins or hands over.
+		options = append(options,
+			app.WithVoters(cfg.Voters),
+			app.WithStandBys(cfg.StandBys),
+			app.WithRolesAdjustmentFrequency(noAdjustment),
+		)
+	}
+	dq, err := app.New(cfg.Dir, options...)
+	if err != nil {
+		return errors.Wrap(err, "can't create dqlite app")
+	}
+	s.dq = dq
+	if err := dq.Ready(ctx); err != nil {
+		dq.Close()
+		return errors.Wrap(err, "node failed to join the cluster")
+	}
+	if err := s.loadMaintenance(ctx); err != nil {
+		dq.Close()
+		return err
+	}
+	// a drained node keeps the role it was drained with until undrained
+	if !s.Maintenance().Drained {
+		if err := s.assignRole(ctx); err != nil {
+			dq.Close()
+			return err
+		}
+	}
+	if cfg.Ready != nil {
+		if err := cfg.Ready(ctx, dq); err != nil {
+			dq.Close()
+			return err
+		}
+	}
+
+	var hctx context.Context
+	hctx, s.cancel = context.WithCancel(context.Background())
+	if cfg.Handlers != nil {
+		for _, route := range cfg.Handlers(hctx, dq) {
+			fn := route.Func
+			if route.Write {
+				fn = s.writes.handler(fn)
+			}
+			s.mux.HandleFunc(route.Path, fn)
+		}
+	}
+	s.mux.HandleFunc("/shutdown", s.handleShutdown)
+	s.mux.HandleFunc("/maintenance", s.handleMaintenance)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/status/balance", s.handleBalance)
+	if cfg.Voters > 0 {
+		go s.balance(hctx)
+	}
+
+	if cfg.Web == "" {
+		return nil
+	}
+	s.listener, err = net.Listen("tcp", cfg.Web)
+	if err != nil {
+		s.cancel()
+		dq.Close()
+		return err
+	}
+	s.web = &http.Server{Handler: s.mux}
+	go func() {
+		if err := s.web.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			log.Println("web server failed:", err)
+		}
+	}()
+	log.Println("serving web api on:", s.listener.Addr())
+	return nil
+}
+
+// Stop shuts down the node without disrupting the cluster: it refuses
+// further writes, waits for those in progress to finish (for up to the
+// drain timeout), hands its leadership and voting role over to another
+// node and, if so configured, leaves the cluster, before it stops
+// serving the web api and closes. The context bounds the whole shutdown.
+func (s *Server) Stop(ctx context.Context) error {
+	if s.cancel != nil {
+		// stops balancing, which would otherwise undo the handover
+		s.cancel()
+	}
+	s.writes.close()
+	dctx, cancel := context.WithTimeout(ctx, s.cfg.DrainTimeout)
+	if err := s.writes.wait(dctx); err != nil {
+		log.Println("writes still in progress at shutdown:", err)
+	}
+	cancel()
+
+	if s.dq != nil {
+		log.Println("handing over cluster responsibilities")
+		if err := s.dq.Handover(ctx); err != nil {
+			log.Println("handover failed:", err)
+		}
+		if s.cfg.LeaveOnShutdown {
+			if err := s.leave(ctx); err != nil {
+				log.Println("can't leave cluster:", err)
+			}
+		}
+	}
+
+	return s.Close(ctx)
+}
+
+// Close stops serving the web api and closes the node at once, without
+// handing anything over, as when the whole cluster is going down
+func (s *Server) Close(ctx context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.web != nil {
+		if err := s.web.Shutdown(ctx); err != nil {
+			log.Println("web server shutdown:", err)
+		}
+	}
+	if s.dq == nil {
+		return nil
+	}
+	log.Println("closing application")
+	err := s.dq.Close()
+	log.Println("application has shut down")
+	return err
+}
+
+// leave removes the node from the cluster
+func (s *Server) leave(ctx context.Context) error {
+	leader, err := s.dq.Leader(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't connect to cluster leader")
+	}
+	defer leader.Close()
+	log.Printf("removing node %d from the cluster\n", s.dq.ID())
+	return leader.Remove(ctx, s.dq.ID())
+}
+
+// Handler returns the web api, to be served by another http server if
+// the config gave no web address
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// App returns the dqlite app of the node, once it has started
+func (s *Server) App() *app.App {
+	return s.dq
+}
+
+// Done is closed when a shutdown is requested through the web api
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// NodeStatus describes a member of the cluster
+type NodeStatus struct {
+	ID      uint64
+	Address string
+	Role    string
+	Leader  bool `json:",omitempty"`
+}
+
+// Status returns the members of the cluster
+func (s *Server) Status(ctx context.Context) ([]NodeStatus, error) {
+	leader, err := s.dq.Leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer leader.Close()
+	info, err := leader.Leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := leader.Cluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]NodeStatus, len(nodes))
+	for i, node := range nodes {
+		status[i] = NodeStatus{
+			ID:      node.ID,
+			Address: node.Address,
+			Role:    node.Role.String(),
+			Leader:  info != nil && node.ID == info.ID,
+		}
+	}
+	return status, nil
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status)
+}
+
+// Balance returns the role targets and the decisions made by this node to meet them
+func (s *Server) Balance() BalanceStatus {
+	return BalanceStatus{
+		Voters:    s.cfg.Voters,
+		StandBys:  s.cfg.StandBys,
+		Decisions: s.balancer.list(),
+	}
+}
+
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Balance())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		fmt.Fprintln(w, "that method is the wrong one")
+		return
+	}
+	fmt.Fprintln(w, "shutting down")
+	s.once.Do(func() { close(s.done) })
+}
+
+// assignRole assigns the configured role to the node, unless it already has it
+func (s *Server) assignRole(ctx context.Context) error {
+	if s.cfg.Role == "" {
+		return nil
+	}
+	want, err := ParseRole(s.cfg.Role)
+	if err != nil {
+		return err
+	}
+	leader, err := s.dq.Leader(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't connect to cluster leader")
+	}
+	defer leader.Close()
+	nodes, err := leader.Cluster(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't get cluster")
+	}
+	for _, node := range nodes {
+		if node.ID != s.dq.ID() {
+			continue
+		}
+		if node.Role == want {
+			return nil
+		}
+		log.Printf("assigning node %d role: %s (was %s)\n", node.ID, want, node.Role)
+		return errors.Wrapf(leader.Assign(ctx, node.ID, want), "can't assign role: %s", s.cfg.Role)
+	}
+	log.Printf("node %d is not a cluster member, role %s not assigned\n", s.dq.ID(), s.cfg.Role)
+	return nil
+}
+
+// tlsConfig returns the listening and dialing TLS configs for the key pair
+func tlsConfig(certFile, keyFile string) (*tls.Config, *tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, nil, fmt.Errorf("bad certificate")
+	}
+	listen, dial := app.SimpleTLSConfig(cert, pool)
+	return listen, dial, nil
+}
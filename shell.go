@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/chzyer/readline"
+	"github.com/paulstuart/dqlited/script"
+	"github.com/pkg/errors"
+)
+
+const (
+	shellPrompt    = "%s> "
+	shellContinue  = "   ...> "
+	shellHistory   = ".dqlited_history"
+	shellHelpIndex = `.connect DB          switch to database DB
+.headers on|off      turn display of headers on or off
+.help                show this message
+.quit                exit this program
+.timer on|off        turn SQL timer on or off
+`
+)
+
+// errQuit signals the shell to exit
+var errQuit = errors.New("quit")
+
+// Shell is an interactive session against the cluster, modeled after sqlite3
+type Shell struct {
+	ctx     context.Context
+	kp      *KeyPair
+	cluster []string
+	logger  LogFunc
+	dbName  string
+	dx      *DBX
+	rl      *readline.Instance
+	w       io.Writer
+	header  bool
+	mode    string
+	timer   bool
+}
+
+// defaultHistory returns the path of the shell history file
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, shellHistory)
+}
+
+// NewShell returns a shell connected to the given database
+func NewShell(ctx context.Context, kp *KeyPair, dbName, history string, cluster []string, logger LogFunc) (*Shell, error) {
+	sh := &Shell{
+		ctx:     ctx,
+		kp:      kp,
+		cluster: cluster,
+		logger:  logger,
+		w:       os.Stdout,
+		header:  true,
+		mode:    defaultFormat,
+	}
+	if err := sh.connect(dbName); err != nil {
+		return nil, err
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:                 sh.prompt(),
+		HistoryFile:            history,
+		HistorySearchFold:      true,
+		DisableAutoSaveHistory: true,
+		InterruptPrompt:        "^C",
+		EOFPrompt:              ".quit",
+	})
+	if err != nil {
+		sh.dx.Close()
+		return nil, errors.Wrap(err, "can't create readline")
+	}
+	sh.rl = rl
+	return sh, nil
+}
+
+func (sh *Shell) prompt() string {
+	return fmt.Sprintf(shellPrompt, sh.dbName)
+}
+
+// connect opens the named database, applying the current display settings.
+// The previous database is released only once the new one is open, so a
+// failed switch leaves the shell where it was.
+func (sh *Shell) connect(dbName string) error {
+	if sh.dx != nil && dbName == sh.dbName {
+		return nil
+	}
+	dx, err := NewConnection(sh.ctx, sh.kp, dbName, sh.cluster, sh.logger)
+	if err != nil {
+		return errors.Wrapf(err, "can't connect to database: %s", dbName)
+	}
+	if sh.dx != nil {
+		sh.dx.Close()
+	}
+	sh.dx = dx
+	sh.dbName = dbName
+	sh.apply()
+	return nil
+}
+
+// apply copies the display settings to the database handler
+func (sh *Shell) apply() {
+	sh.dx.w = sh.w
+	sh.dx.header = sh.header
+	sh.dx.setMode(sh.mode)
+}
+
+// Close releases the database connection and terminal
+func (sh *Shell) Close() error {
+	sh.rl.Close()
+	return sh.dx.Close()
+}
+
+// Run reads and evaluates statements until the user quits
+func (sh *Shell) Run() error {
+	var buf strings.Builder
+	for {
+		line, err := sh.rl.Readline()
+		switch {
+		case err == readline.ErrInterrupt:
+			// abandon the current statement, like sqlite3
+			buf.Reset()
+			sh.rl.SetPrompt(sh.prompt())
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		text := strings.TrimSpace(line)
+		if buf.Len() == 0 {
+			if text == "" {
+				continue
+			}
+			if strings.HasPrefix(text, ".") {
+				sh.rl.SaveHistory(text)
+				if err := sh.command(text); err == errQuit {
+					return nil
+				} else if err != nil {
+					fmt.Fprintln(sh.w, "Error:", describeError(err))
+				}
+				continue
+			}
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if !script.Complete(buf.String()) {
+			sh.rl.SetPrompt(shellContinue)
+			continue
+		}
+		text = strings.TrimSpace(buf.String())
+		buf.Reset()
+		sh.rl.SetPrompt(sh.prompt())
+		sh.rl.SaveHistory(text)
+		statements, err := script.SplitStatements(text)
+		if err != nil {
+			fmt.Fprintln(sh.w, "Error:", describeError(err))
+			continue
+		}
+		for _, statement := range statements {
+			if err := sh.eval(statement); err != nil {
+				fmt.Fprintln(sh.w, "Error:", describeError(err))
+				break
+			}
+		}
+	}
+}
+
+// eval runs the statement, retrying once if the leader has gone away.
+// The pool discards the failed connection and the driver finds the new
+// leader for the next one. Only read-only statements are retried, as a
+// write may have been applied before its connection failed.
+func (sh *Shell) eval(statement string) error {
+	started := time.Now()
+	err := sh.dx.Eval(statement)
+	if err != nil && !isSqliteError(err) && script.Classify(statement).ReadOnly {
+		err = sh.dx.Eval(statement)
+	}
+	if sh.timer {
+		fmt.Fprintf(sh.w, "Run Time: real %.3f\n", time.Since(started).Seconds())
+	}
+	return err
+}
+
+// command handles the shell's own dot-commands, deferring the rest to Eval
+func (sh *Shell) command(text string) error {
+	args := strings.Fields(text)
+	switch args[0] {
+	case ".quit", ".exit":
+		return errQuit
+	case ".help":
+		fmt.Fprint(sh.w, shellHelpIndex)
+		fmt.Fprint(sh.w, dotHelp)
+	case ".connect":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: .connect DB")
+		}
+		if err := sh.connect(args[1]); err != nil {
+			return err
+		}
+		sh.rl.SetPrompt(sh.prompt())
+	case ".headers":
+		on, err := onOff(args)
+		if err != nil {
+			return err
+		}
+		sh.header = on
+		sh.apply()
+	case ".timer":
+		on, err := onOff(args)
+		if err != nil {
+			return err
+		}
+		sh.timer = on
+	case ".mode":
+		if err := sh.dx.dotCommand(text); err != nil {
+			return err
+		}
+		sh.mode = sh.dx.mode()
+	default:
+		return sh.eval(text)
+	}
+	return nil
+}
+
+// onOff parses the boolean argument of a dot-command
+func onOff(args []string) (bool, error) {
+	if len(args) != 2 {
+		return false, fmt.Errorf("usage: %s on|off", args[0])
+	}
+	switch strings.ToLower(args[1]) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s: expected on or off, got %q", args[0], args[1])
+}
+
+// isSqliteError reports whether the error came from sqlite itself,
+// as opposed to a connection or leadership failure
+func isSqliteError(err error) bool {
+	cause := errors.Cause(err)
+	if derr, ok := cause.(driver.Error); ok {
+		return derr.Code > 0
+	}
+	_, ok := cause.(SqliteError)
+	return ok
+}
+
+// describeError formats an error the way the cli reports it
+func describeError(err error) string {
+	cause := errors.Cause(err)
+	if sqlErr, ok := cause.(SqliteError); ok {
+		code, msg := sqlErr.SqliteError()
+		return fmt.Sprintf("SqliteError (%d): %s", code, msg)
+	}
+	return fmt.Sprintf("(%T): %v", cause, err)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	// storeRefresh is how long the known membership is trusted before
+	// it is refreshed from the leader
+	storeRefresh = 30 * time.Second
+
+	// storeCacheDir holds the cached membership of named clusters, relative to $HOME
+	storeCacheDir = ".dqlited"
+)
+
+// nodeCache names the on-disk cache of cluster membership (none if blank),
+// either a path to a yaml file or a name for files within ~/.dqlited
+var nodeCache string
+
+// RefreshStore is a client.NodeStore that is seeded with addresses
+// and then learns the actual membership of the cluster from its leader.
+// When backed by a yaml cache, the membership it learned is available
+// to later runs, after the seed nodes may have been removed.
+type RefreshStore struct {
+	mu        sync.Mutex
+	store     client.NodeStore
+	dial      client.DialFunc
+	log       client.LogFunc
+	discovery *Discovery // finds nodes the cluster may not know about yet
+	updated   time.Time
+}
+
+// NewRefreshStore returns a store seeded with the given addresses,
+// merged with the membership cached in the yaml file, if one is named
+func NewRefreshStore(ctx context.Context, seeds []string, cache string, dial client.DialFunc, logger client.LogFunc) (*RefreshStore, error) {
+	var store client.NodeStore = client.NewInmemNodeStore()
+	if cache != "" {
+		if err := os.MkdirAll(filepath.Dir(cache), 0755); err != nil {
+			return nil, errors.Wrapf(err, "can't create node cache directory for: %s", cache)
+		}
+		yaml, err := client.NewYamlNodeStore(cache)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't open node cache: %s", cache)
+		}
+		store = yaml
+	}
+	nodes, err := store.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		known[node.Address] = true
+	}
+	// seeds have no ID until the leader reports it
+	for _, address := range seeds {
+		if !known[address] {
+			known[address] = true
+			nodes = append(nodes, client.NodeInfo{Address: address})
+		}
+	}
+	if err := store.Set(ctx, nodes); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = client.DefaultLogFunc
+	}
+	return &RefreshStore{store: store, dial: dial, log: logger}, nil
+}
+
+// Get returns the known cluster nodes, first refreshing them if they are stale
+func (s *RefreshStore) Get(ctx context.Context) ([]client.NodeInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.updated) > storeRefresh {
+		// the known nodes are still usable if the refresh fails
+		if err := s.refresh(ctx); err != nil {
+			s.log(client.LogWarn, "can't refresh cluster nodes: %v", err)
+		}
+	}
+	return s.store.Get(ctx)
+}
+
+// Set replaces the known cluster nodes
+func (s *RefreshStore) Set(ctx context.Context, nodes []client.NodeInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Set(ctx, nodes)
+}
+
+// refresh replaces the known nodes with the membership reported by the leader
+func (s *RefreshStore) refresh(ctx context.Context) error {
+	s.updated = time.Now()
+	if s.discovery != nil {
+		if err := s.rediscover(ctx); err != nil {
+			s.log(client.LogWarn, "%v", err)
+		}
+	}
+	leader, err := client.FindLeader(ctx, s.store, client.WithDialFunc(s.dial), client.WithLogFunc(s.log))
+	if err != nil {
+		return err
+	}
+	defer leader.Close()
+	nodes, err := leader.Cluster(ctx)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	return s.store.Set(ctx, nodes)
+}
+
+// rediscover adds the nodes found by discovery to the known nodes
+func (s *RefreshStore) rediscover(ctx context.Context) error {
+	addresses, err := s.discovery.Addresses(ctx)
+	if err != nil {
+		return err
+	}
+	nodes, err := s.store.Get(ctx)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		known[node.Address] = true
+	}
+	added := false
+	for _, address := range addresses {
+		if !known[address] {
+			nodes = append(nodes, client.NodeInfo{Address: address})
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+	return s.store.Set(ctx, nodes)
+}
+
+// nodeCachePath returns the path of the named node cache for the cluster.
+// A name is qualified by the cluster, so clusters reached under the same
+// name (e.g., a context with --cluster given) don't share their membership.
+// A path is used as given.
+func nodeCachePath(name string, cluster []string) (string, error) {
+	if name == "" || strings.ContainsRune(name, os.PathSeparator) || strings.HasSuffix(name, ".yaml") {
+		return name, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, storeCacheDir, name+"-"+clusterID(cluster)+".yaml"), nil
+}
+
+// clusterID returns a short identifier of the cluster, from its addresses
+func clusterID(cluster []string) string {
+	sum := sha256.Sum256([]byte(newClusterKey(nil, cluster).cluster))
+	return hex.EncodeToString(sum[:4])
+}
+
+// getStore returns the node store for the cluster, using the node cache if
+// one is set. When the cluster is given as a discovery spec, the nodes found
+// through the resolver seed the store and discovery is repeated with each refresh.
+func getStore(ctx context.Context, cluster []string, resolver Resolver, dial client.DialFunc, logger client.LogFunc) (client.NodeStore, error) {
+	if len(cluster) == 0 {
+		cluster = defaultCluster
+	}
+	cache, err := nodeCachePath(nodeCache, cluster)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := clusterDiscovery(cluster, resolver)
+	if err != nil {
+		return nil, err
+	}
+	if discovery != nil {
+		// the spec is no address, so rely on the cache until discovery succeeds
+		cluster = nil
+		if addresses, err := discovery.Addresses(ctx); err != nil {
+			log.Println(err)
+		} else {
+			cluster = addresses
+		}
+	}
+	store, err := NewRefreshStore(ctx, cluster, cache, dial, logger)
+	if err != nil {
+		return nil, err
+	}
+	store.discovery = discovery
+	return store, nil
+}